@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"io"
@@ -8,15 +9,20 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
+	"time"
 
 	"github.com/rousage/httpfromtcp/internal/headers"
+	"github.com/rousage/httpfromtcp/internal/middleware"
 	"github.com/rousage/httpfromtcp/internal/request"
 	"github.com/rousage/httpfromtcp/internal/response"
+	"github.com/rousage/httpfromtcp/internal/router"
 	"github.com/rousage/httpfromtcp/internal/server"
 )
 
+// shutdownTimeout is how long in-flight requests get to finish on SIGTERM
+const shutdownTimeout = 10 * time.Second
+
 const (
 	port   = 42069
 	res400 = `<html>
@@ -52,78 +58,116 @@ const (
 )
 
 func main() {
-	server, err := server.Serve(port, handler)
+	// Panics are recovered by the server itself
+	handler := server.Chain(
+		middleware.RequestID(),
+	)(newRouter().Serve)
+
+	opts := []server.Option{
+		server.WithReadHeaderTimeout(10 * time.Second),
+		server.WithReadBodyTimeout(30 * time.Second),
+		server.WithWriteTimeout(time.Minute),
+		server.WithIdleTimeout(2 * time.Minute),
+		server.WithAccessLog(os.Stdout, server.LogCombined),
+	}
+	// Serve HTTPS when a certificate is configured
+	if certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"); certFile != "" && keyFile != "" {
+		opts = append(opts, server.WithTLSCertificate(certFile, keyFile))
+	}
+
+	srv, err := serve(handler, opts)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
-	log.Println("Server started on port", port)
+	log.Println("Server started on", srv.Addr())
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+		return
+	}
 	log.Println("Server gracefully stopped")
 }
 
-func handler(w *response.Writer, req *request.Request) {
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin") {
-		proxyHandler(w, req)
-		return
+// serve serves on the socket passed by systemd if the server was socket
+// activated, or on port otherwise.
+func serve(handler server.Handler, opts []server.Option) (*server.Server, error) {
+	listeners, err := server.SystemdListeners()
+	if err != nil {
+		return nil, err
 	}
-	if req.RequestLine.RequestTarget == "/video" {
-		videoHandler(w, req)
-		return
+	if len(listeners) > 0 {
+		return server.ServeListener(listeners[0], handler, opts...)
 	}
 
-	hs := response.GetDefaultHeaders(0)
-	hs.Set("Content-Type", "text/html")
+	return server.Serve(port, handler, opts...)
+}
 
-	if req.RequestLine.RequestTarget == "/yourproblem" {
-		hs.Set("Content-Type", "text/html")
-		hs.Set("Content-Length", fmt.Sprintf("%d", len(res400)))
-		w.WriteStatusLine(response.StatusBadRequest)
-		w.WriteHeaders(hs)
-		w.WriteBody([]byte(res400))
-		return
-	}
-	if req.RequestLine.RequestTarget == "/myproblem" {
-		hs.Set("Content-Type", "text/html")
-		hs.Set("Content-Length", fmt.Sprintf("%d", len(res500)))
-		w.WriteStatusLine(response.StatusInternalServerError)
-		w.WriteHeaders(hs)
-		w.WriteBody([]byte(res500))
-		return
-	}
+func newRouter() *router.Router {
+	rt := router.New()
+	rt.Handle("GET", "/httpbin", proxyHandler)
+	rt.Handle("GET", "/httpbin/{path...}", proxyHandler)
+	rt.Handle("GET", "/video", videoHandler)
+	rt.Handle("GET", "/yourproblem", yourProblemHandler)
+	rt.Handle("GET", "/myproblem", myProblemHandler)
+	rt.Handle("GET", "/{path...}", okHandler)
+	rt.Handle("POST", "/{path...}", okHandler)
+
+	return rt
+}
 
-	res := []byte(res200)
+func okHandler(w *response.Writer, req *request.Request) {
+	writeHTML(w, response.StatusOK, res200)
+}
 
-	hs.Set("Content-Length", fmt.Sprintf("%d", len(res)))
-	w.WriteStatusLine(response.StatusOK)
+func yourProblemHandler(w *response.Writer, req *request.Request) {
+	writeHTML(w, response.StatusBadRequest, res400)
+}
+
+func myProblemHandler(w *response.Writer, req *request.Request) {
+	writeHTML(w, response.StatusInternalServerError, res500)
+}
+
+// writeHTML writes a complete HTML response, leaving its framing to the
+// writer.
+func writeHTML(w *response.Writer, statusCode response.StatusCode, body string) {
+	hs := response.DefaultHeaders()
+	hs.Set("Content-Type", "text/html")
+	w.WriteStatusLine(statusCode)
 	w.WriteHeaders(hs)
-	w.WriteBody(res)
+	w.WriteBody([]byte(body))
 }
 
 func proxyHandler(w *response.Writer, req *request.Request) {
-	path := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin")
-	url := "https://httpbin.org" + path
-
-	resp, err := http.Get(url)
+	target := *req.RequestLine.URL
+	target.Scheme = "https"
+	target.Host = "httpbin.org"
+	target.Path = "/" + req.PathValue("path")
+	target.RawPath = ""
+	url := target.String()
+
+	// Stop proxying when the client goes away or the server shuts down
+	var resp *http.Response
+	proxyReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, url, nil)
+	if err == nil {
+		resp, err = http.DefaultClient.Do(proxyReq)
+	}
 	if err != nil {
-		hs := response.GetDefaultHeaders(len(res500))
-		hs.Set("Content-Type", "text/html")
-		w.WriteStatusLine(response.StatusInternalServerError)
-		w.WriteHeaders(hs)
-		w.WriteBody([]byte(res500))
+		writeHTML(w, response.StatusInternalServerError, res500)
 		return
 	}
 	defer resp.Body.Close()
 
 	w.WriteStatusLine(response.StatusOK)
 
-	hs := response.GetDefaultHeaders(0)
+	hs := response.DefaultHeaders()
 	hs.Set("Transfer-Encoding", "chunked")
 	hs.Set("Trailer", "x-content-sha256, x-content-length")
-	delete(hs, "content-length")
 
 	w.WriteHeaders(hs)
 
@@ -136,6 +180,8 @@ func proxyHandler(w *response.Writer, req *request.Request) {
 		if n > 0 {
 			body = append(body, buf[:n]...)
 			_, err = w.WriteChunkedBody(buf[:n])
+			// Stream the response as it comes
+			w.Flush()
 		}
 		if err == io.EOF {
 			break
@@ -156,19 +202,13 @@ func proxyHandler(w *response.Writer, req *request.Request) {
 }
 
 func videoHandler(w *response.Writer, req *request.Request) {
-	hs := response.GetDefaultHeaders(0)
-
 	video, err := os.ReadFile("assets/vim.mp4")
 	if err != nil {
-		hs.Set("Content-Type", "text/html")
-		hs.Set("Content-Length", fmt.Sprintf("%d", len(res500)))
-		w.WriteStatusLine(response.StatusInternalServerError)
-		w.WriteHeaders(hs)
-		w.WriteBody([]byte(res500))
+		writeHTML(w, response.StatusInternalServerError, res500)
 		return
 	}
 
-	hs.Set("Content-Length", fmt.Sprintf("%d", len(video)))
+	hs := response.DefaultHeaders()
 	hs.Set("Content-Type", "video/mp4")
 
 	w.WriteStatusLine(response.StatusOK)
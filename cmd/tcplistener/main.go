@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"net"
 
@@ -29,10 +30,14 @@ func main() {
 
 		fmt.Printf("Request line:\n- Method: %s\n- Target: %s\n- Version: %s\n", req.RequestLine.Method, req.RequestLine.RequestTarget, req.RequestLine.HttpVersion)
 		fmt.Printf("Headers:\n")
-		for key, val := range req.Headers {
+		for key, val := range req.Headers.All() {
 			fmt.Printf("- %s: %s\n", key, val)
 		}
-		fmt.Printf("Body:\n%s", req.Body)
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Body:\n%s", body)
 
 		log.Println("Connection closed")
 	}
@@ -0,0 +1,30 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrMalformedFieldLine = errors.New("malformed header field line")
+	ErrInvalidFieldName   = errors.New("invalid header field name")
+	ErrInvalidFieldValue  = errors.New("invalid header field value")
+	ErrObsFold            = errors.New("obsolete line folding")
+	ErrBareLF             = errors.New("line ended by a bare LF")
+)
+
+// ParseError is returned by Parse for a field line that is not valid.
+// Err is one of the sentinel errors above, so callers can use errors.Is.
+type ParseError struct {
+	Err error
+	// Offset is the position of the offending byte in the data given to Parse
+	Offset int
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
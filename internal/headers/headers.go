@@ -1,87 +1,229 @@
 package headers
 
 import (
-	"errors"
+	"bytes"
+	"iter"
+	"slices"
 	"strings"
 )
 
-const crlf = "\r\n"
+// Field is a single field line, with its name as it was received or set.
+type Field struct {
+	Name  string
+	Value string
+}
 
-type Headers map[string]string
+// Headers holds the header or trailer fields of a message, one entry per
+// field line, in order. Names are matched case-insensitively but keep their
+// original casing, and repeated fields are never joined so values such as
+// Set-Cookie stay intact.
+type Headers struct {
+	fields []Field
+}
 
-func NewHeaders() Headers {
-	return Headers{}
+func NewHeaders() *Headers {
+	return &Headers{}
 }
 
-func (h Headers) Get(key string) string {
-	return h[strings.ToLower(key)]
+// Get returns the value of the first field with the given name, or "" if
+// there is none.
+func (h *Headers) Get(key string) string {
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, key) {
+			return f.Value
+		}
+	}
+	return ""
 }
 
-func (h Headers) Set(key, value string) {
-	h[strings.ToLower(key)] = value
+// Values returns the values of all the fields with the given name, in order.
+func (h *Headers) Values(key string) []string {
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, key) {
+			values = append(values, f.Value)
+		}
+	}
+	return values
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
-	headersStr := string(data)
-	// if \r\n is not in the string, it needs more data
-	if !strings.Contains(headersStr, crlf) {
-		return 0, false, nil
+// Add appends a field line, keeping any existing field with the same name.
+func (h *Headers) Add(key, value string) {
+	h.fields = append(h.fields, Field{Name: key, Value: value})
+}
+
+// Set replaces all the fields with the given name by a single one, at the
+// position of the first of them, or appends it if there was none.
+func (h *Headers) Set(key, value string) {
+	idx := slices.IndexFunc(h.fields, func(f Field) bool {
+		return strings.EqualFold(f.Name, key)
+	})
+	if idx == -1 {
+		h.Add(key, value)
+		return
 	}
-	// if CRLF is at the start of the data, it's the end of headers
-	// return done=true and consume the CRLF
-	if strings.HasPrefix(headersStr, crlf) {
-		return len(crlf), true, nil
+
+	h.fields[idx] = Field{Name: key, Value: value}
+	rest := slices.DeleteFunc(h.fields[idx+1:], func(f Field) bool {
+		return strings.EqualFold(f.Name, key)
+	})
+	h.fields = h.fields[:idx+1+len(rest)]
+}
+
+// Del removes all the fields with the given name.
+func (h *Headers) Del(key string) {
+	h.fields = slices.DeleteFunc(h.fields, func(f Field) bool {
+		return strings.EqualFold(f.Name, key)
+	})
+}
+
+// Len returns the number of field lines.
+func (h *Headers) Len() int {
+	return len(h.fields)
+}
+
+// All iterates over the field lines in order.
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		for _, f := range h.fields {
+			if !yield(f.Name, f.Value) {
+				return
+			}
+		}
+	}
+}
+
+// HasToken reports whether the comma-separated lists in the fields with the
+// given name contain token, compared case-insensitively
+// (e.g. "Connection: close").
+func (h *Headers) HasToken(key, token string) bool {
+	for _, value := range h.Values(key) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Parse parses a single field line at the start of data into h. It returns
+// done=true once the empty line ending the field section is parsed, and
+// n=0 with no error if data does not hold a complete line yet.
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
+	return h.parse(data, false)
+}
+
+// ParseLenient is like Parse, but also accepts lines ended by a bare LF and
+// obsolete line folding, which is replaced by a single space. Both are
+// common request smuggling vectors, so it should only be used for peers
+// known to send them.
+// https://datatracker.ietf.org/doc/html/rfc9112#name-obsolete-line-folding
+func (h *Headers) ParseLenient(data []byte) (n int, done bool, err error) {
+	return h.parse(data, true)
+}
+
+func (h *Headers) parse(data []byte, lenient bool) (int, bool, error) {
+	lineLen, n, err := NextLine(data, lenient)
+	if err != nil {
+		return 0, false, err
+	}
+	// no line ending yet, it needs more data
+	if n == 0 {
+		return 0, false, nil
 	}
 
-	headerStr := strings.Split(headersStr, crlf)[0]
+	headerStr := string(data[:lineLen])
+	// an empty line is the end of headers
 	if headerStr == "" {
-		return 0, false, errors.New("empty header")
+		return n, true, nil
 	}
 
-	parts := strings.SplitN(headerStr, ":", 2)
-	if len(parts) != 2 {
-		return 0, false, errors.New("invalid header")
+	// A line starting with whitespace continues the previous field value
+	if headerStr[0] == ' ' || headerStr[0] == '\t' {
+		if !lenient || len(h.fields) == 0 {
+			return 0, false, &ParseError{Err: ErrObsFold, Offset: 0}
+		}
+
+		folded := strings.Trim(headerStr, " \t")
+		if !IsValidValue(folded) {
+			return 0, false, &ParseError{Err: ErrInvalidFieldValue, Offset: 0}
+		}
+		if folded != "" {
+			last := &h.fields[len(h.fields)-1]
+			last.Value += " " + folded
+		}
+
+		return n, false, nil
 	}
 
-	parsedKey, ok := parseKey(parts[0])
-	if !ok {
-		return 0, false, errors.New("invalid header key")
+	colon := strings.Index(headerStr, ":")
+	if colon == -1 {
+		return 0, false, &ParseError{Err: ErrMalformedFieldLine, Offset: len(headerStr)}
+	}
+
+	parsedKey, badIdx := parseKey(headerStr[:colon])
+	if badIdx != -1 {
+		return 0, false, &ParseError{Err: ErrInvalidFieldName, Offset: badIdx}
 	}
-	parsedValue, ok := parseValue(parts[1])
+	parsedValue, ok := parseValue(headerStr[colon+1:])
 	if !ok {
-		return 0, false, errors.New("invalid header value")
+		return 0, false, &ParseError{Err: ErrInvalidFieldValue, Offset: colon + 1}
 	}
 
-	val, ok := h[parsedKey]
-	if ok {
-		h[parsedKey] = val + ", " + parsedValue
-	} else {
-		h[parsedKey] = parsedValue
+	h.Add(parsedKey, parsedValue)
+
+	return n, false, nil
+}
+
+// NextLine finds the line at the start of data. It returns the length of the
+// line without its terminator and the number of bytes to consume including
+// it, or zeros if the line is not complete yet. A bare LF is only accepted as
+// a line terminator in lenient mode.
+// https://datatracker.ietf.org/doc/html/rfc9112#name-message-parsing
+func NextLine(data []byte, lenient bool) (int, int, error) {
+	idx := bytes.IndexByte(data, '\n')
+	if idx == -1 {
+		return 0, 0, nil
+	}
+	if idx > 0 && data[idx-1] == '\r' {
+		return idx - 1, idx + 1, nil
+	}
+	if !lenient {
+		return 0, 0, &ParseError{Err: ErrBareLF, Offset: idx}
 	}
 
-	return len(headerStr) + len(crlf), false, nil
+	return idx, idx + 1, nil
 }
 
-func parseKey(key string) (string, bool) {
+// parseKey validates a field name and returns it. If it is not valid, it
+// returns the index of the offending byte instead of -1.
+func parseKey(key string) (string, int) {
 	// There cannot be an empty space between the key and the colon
-	if strings.HasSuffix(key, " ") {
-		return "", false
+	if strings.HasSuffix(key, " ") || strings.HasSuffix(key, "\t") {
+		return "", len(key) - 1
 	}
-
-	trimmedKey := strings.TrimSpace(key)
-	if trimmedKey == "" {
-		return "", false
+	if key == "" {
+		return "", 0
 	}
 
-	if !isValidToken(trimmedKey) {
-		return "", false
+	for i, c := range key {
+		if !isTokenChar(c) {
+			return "", i
+		}
 	}
 
-	return strings.ToLower(trimmedKey), true
+	return key, -1
 }
 
+// IsToken reports whether s is a valid token, the syntax of field names
+// and methods.
 // https://datatracker.ietf.org/doc/html/rfc9110#name-tokens
-func isValidToken(s string) bool {
+func IsToken(s string) bool {
+	if s == "" {
+		return false
+	}
+
 	for _, c := range s {
 		if !isTokenChar(c) {
 			return false
@@ -104,11 +246,35 @@ func isTokenChar(c rune) bool {
 	return false
 }
 
+// IsValidValue reports whether v can be sent as a field value: visible
+// characters, obs-text, and spaces or tabs between them. CR and LF in
+// particular are rejected, as they would end the field line.
+// https://datatracker.ietf.org/doc/html/rfc9110#name-field-values
+func IsValidValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c == ' ' || c == '\t' {
+			if i == 0 || i == len(v)-1 {
+				return false
+			}
+			continue
+		}
+		if c < 0x21 || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
 func parseValue(value string) (string, bool) {
-	trimmedValue := strings.TrimSpace(value)
+	trimmedValue := strings.Trim(value, " \t")
 	if trimmedValue == "" {
 		return "", false
 	}
+	// CR, LF and other control characters could smuggle another field
+	if !IsValidValue(trimmedValue) {
+		return "", false
+	}
 
 	return trimmedValue, true
 }
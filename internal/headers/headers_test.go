@@ -14,7 +14,7 @@ func TestHeaders(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
@@ -24,7 +24,7 @@ func TestHeaders(t *testing.T) {
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.Equal(t, 37, n)
 	assert.False(t, done)
 
@@ -33,21 +33,21 @@ func TestHeaders(t *testing.T) {
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "application/json", headers["content-type"])
+	assert.Equal(t, "application/json", headers.Get("content-type"))
 	assert.Equal(t, 32, n)
 	assert.False(t, done)
 	n, done, err = headers.Parse(data[32:])
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "value", headers["header"])
+	assert.Equal(t, "value", headers.Get("header"))
 	assert.Equal(t, 15, n)
 	assert.False(t, done)
 	n, done, err = headers.Parse(data[47:])
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
-	assert.Equal(t, "application/json", headers["content-type"])
-	assert.Equal(t, "value", headers["header"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
+	assert.Equal(t, "application/json", headers.Get("content-type"))
+	assert.Equal(t, "value", headers.Get("header"))
 	assert.Equal(t, 2, n)
 	assert.True(t, done)
 
@@ -57,19 +57,19 @@ func TestHeaders(t *testing.T) {
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "value-one", headers["header"])
+	assert.Equal(t, "value-one", headers.Get("header"))
 	assert.Equal(t, 19, n)
 	assert.False(t, done)
 	n, done, err = headers.Parse(data[19:])
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "value-one, value-two", headers["header"])
+	assert.Equal(t, []string{"value-one", "value-two"}, headers.Values("header"))
 	assert.Equal(t, 19, n)
 	assert.False(t, done)
 	n, done, err = headers.Parse(data[38:])
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "value-one, value-two", headers["header"])
+	assert.Equal(t, []string{"value-one", "value-two"}, headers.Values("header"))
 	assert.Equal(t, 2, n)
 	assert.True(t, done)
 
@@ -89,3 +89,104 @@ func TestHeaders(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 }
+
+func TestHeadersHasToken(t *testing.T) {
+	headers := NewHeaders()
+	headers.Set("Connection", "keep-alive, Close")
+	assert.True(t, headers.HasToken("connection", "close"))
+	assert.True(t, headers.HasToken("Connection", "keep-alive"))
+	assert.False(t, headers.HasToken("Connection", "upgrade"))
+	assert.False(t, headers.HasToken("Transfer-Encoding", "chunked"))
+}
+
+func TestHeadersParseErrors(t *testing.T) {
+	// Test: Missing colon
+	headers := NewHeaders()
+	_, _, err := headers.Parse([]byte("Host localhost\r\n\r\n"))
+	require.ErrorIs(t, err, ErrMalformedFieldLine)
+
+	// Test: Invalid character in name
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("H@st: localhost:42069\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidFieldName)
+	var pErr *ParseError
+	require.ErrorAs(t, err, &pErr)
+	assert.Equal(t, 1, pErr.Offset)
+
+	// Test: Whitespace before colon
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("Host : localhost:42069\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidFieldName)
+	require.ErrorAs(t, err, &pErr)
+	assert.Equal(t, 4, pErr.Offset)
+
+	// Test: Empty value
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("Host:   \r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidFieldValue)
+}
+
+func TestHeadersFields(t *testing.T) {
+	// Test: Order and casing are preserved
+	headers := NewHeaders()
+	data := []byte("Set-Cookie: a=1; Path=/\r\nContent-Type: text/html\r\nset-cookie: b=2, c=3\r\n\r\n")
+	for n := 0; ; {
+		read, done, err := headers.Parse(data[n:])
+		require.NoError(t, err)
+		n += read
+		if done {
+			break
+		}
+	}
+	var fields []Field
+	for name, value := range headers.All() {
+		fields = append(fields, Field{Name: name, Value: value})
+	}
+	assert.Equal(t, []Field{
+		{Name: "Set-Cookie", Value: "a=1; Path=/"},
+		{Name: "Content-Type", Value: "text/html"},
+		{Name: "set-cookie", Value: "b=2, c=3"},
+	}, fields)
+	assert.Equal(t, "a=1; Path=/", headers.Get("SET-COOKIE"))
+	assert.Equal(t, []string{"a=1; Path=/", "b=2, c=3"}, headers.Values("Set-Cookie"))
+
+	// Test: Set replaces all values in place
+	headers.Add("X-Other", "1")
+	headers.Set("Set-Cookie", "d=4")
+	assert.Equal(t, 3, headers.Len())
+	assert.Equal(t, []string{"d=4"}, headers.Values("set-cookie"))
+	fields = fields[:0]
+	for name, value := range headers.All() {
+		fields = append(fields, Field{Name: name, Value: value})
+	}
+	assert.Equal(t, []Field{
+		{Name: "Set-Cookie", Value: "d=4"},
+		{Name: "Content-Type", Value: "text/html"},
+		{Name: "X-Other", Value: "1"},
+	}, fields)
+
+	// Test: Del removes all values
+	headers.Del("content-type")
+	assert.Equal(t, "", headers.Get("Content-Type"))
+	assert.Nil(t, headers.Values("Content-Type"))
+	assert.Equal(t, 2, headers.Len())
+}
+
+func TestFieldSyntax(t *testing.T) {
+	// Test: Tokens
+	assert.True(t, IsToken("X-Request-Id"))
+	assert.False(t, IsToken(""))
+	assert.False(t, IsToken("X Request"))
+	assert.False(t, IsToken("Location:"))
+
+	// Test: Values
+	assert.True(t, IsValidValue("/redirect?to=home"))
+	assert.True(t, IsValidValue("text/html; charset=utf-8"))
+	assert.True(t, IsValidValue(""))
+	assert.True(t, IsValidValue("caf\xc3\xa9"))
+	assert.False(t, IsValidValue("/home\r\nSet-Cookie: admin=1"))
+	assert.False(t, IsValidValue("/home\nX: 1"))
+	assert.False(t, IsValidValue("a\x00b"))
+	assert.False(t, IsValidValue(" leading"))
+	assert.False(t, IsValidValue("trailing\t"))
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"log"
+	"time"
+
+	"github.com/rousage/httpfromtcp/internal/request"
+	"github.com/rousage/httpfromtcp/internal/response"
+	"github.com/rousage/httpfromtcp/internal/server"
+)
+
+// AccessLog returns a middleware logging a line for each request to logger,
+// or log.Default() if nil, once the handler returns. The line holds the
+// request line, the status code, the number of body bytes sent and how long
+// the handler took, e.g. "GET /users/1 HTTP/1.1 200 512 1.2ms". Use
+// server.WithAccessLog for standard log formats.
+func AccessLog(logger *log.Logger) server.Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+
+			logger.Printf("%s %s HTTP/%s %d %d %s",
+				req.RequestLine.Method,
+				req.RequestLine.RequestTarget,
+				req.RequestLine.HttpVersion,
+				w.StatusCode(),
+				w.BodyBytes(),
+				time.Since(start),
+			)
+		}
+	}
+}
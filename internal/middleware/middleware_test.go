@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rousage/httpfromtcp/internal/request"
+	"github.com/rousage/httpfromtcp/internal/response"
+	"github.com/rousage/httpfromtcp/internal/server"
+)
+
+// serve sends the raw request through h and returns the writer and the raw
+// response.
+func serve(t *testing.T, h server.Handler, raw string) (*response.Writer, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	h(w, req)
+	w.Finish()
+	return w, buf.String()
+}
+
+func ok(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(0))
+	w.WriteBody(nil)
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	mark := func(name string) server.Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w *response.Writer, req *request.Request) {
+				calls = append(calls, name)
+				next(w, req)
+			}
+		}
+	}
+
+	h := server.Chain(mark("a"), mark("b"), mark("c"))(ok)
+	serve(t, h, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, []string{"a", "b", "c"}, calls)
+}
+
+func TestRecover(t *testing.T) {
+	var logs bytes.Buffer
+	recoverer := Recover(log.New(&logs, "", 0))
+
+	// Test: Panic before the status line
+	w, res := serve(t, recoverer(func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	}), "GET /boom HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.True(t, w.ShouldClose())
+	assert.Contains(t, logs.String(), "panic serving GET /boom: boom")
+
+	// Test: Panic after a response that wasn't sent yet
+	w, res = serve(t, recoverer(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.DefaultHeaders())
+		w.Write([]byte("partial"))
+		panic("boom")
+	}), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.True(t, w.ShouldClose())
+
+	// Test: Panic after part of the response was sent
+	w, res = serve(t, recoverer(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(10))
+		w.Write([]byte("partial"))
+		w.Flush()
+		panic("boom")
+	}), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\nContent-Type: text/plain\r\n\r\npartial", res)
+	assert.True(t, w.ShouldClose())
+}
+
+func TestRequestID(t *testing.T) {
+	var id string
+	h := RequestID()(func(w *response.Writer, req *request.Request) {
+		id = GetRequestID(req)
+		ok(w, req)
+	})
+
+	// Test: The client's ID is kept
+	_, res := serve(t, h, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-Id: abc-123\r\n\r\n")
+	assert.Equal(t, "abc-123", id)
+	assert.Contains(t, res, "\r\nX-Request-Id: abc-123\r\n")
+
+	// Test: An ID is generated when missing
+	_, res = serve(t, h, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Len(t, id, 32)
+	assert.Contains(t, res, "\r\nX-Request-Id: "+id+"\r\n")
+
+	// Test: An invalid ID is replaced
+	_, _ = serve(t, h, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-Id: a b\r\n\r\n")
+	assert.Len(t, id, 32)
+}
+
+func TestAccessLog(t *testing.T) {
+	var logs bytes.Buffer
+	h := AccessLog(log.New(&logs, "", 0))(ok)
+
+	serve(t, h, "GET /users?id=1 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(logs.String(), "GET /users?id=1 HTTP/1.1 200 0 "))
+}
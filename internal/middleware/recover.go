@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"log"
+
+	"github.com/rousage/httpfromtcp/internal/request"
+	"github.com/rousage/httpfromtcp/internal/response"
+	"github.com/rousage/httpfromtcp/internal/server"
+)
+
+// Recover returns a middleware recovering from panics in the handler, which
+// are answered by server.RecoverPanic and logged to logger. The server
+// already recovers from panics on its own; this middleware is for handlers
+// that must keep a panic from reaching code wrapping them, e.g. other
+// middlewares.
+func Recover(logger *log.Logger) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			defer func() {
+				if v := recover(); v != nil {
+					server.RecoverPanic(logger, w, req, v)
+				}
+			}()
+
+			next(w, req)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/rousage/httpfromtcp/internal/headers"
+	"github.com/rousage/httpfromtcp/internal/request"
+	"github.com/rousage/httpfromtcp/internal/response"
+	"github.com/rousage/httpfromtcp/internal/server"
+)
+
+// RequestIDHeader is the field carrying the ID of a request, both in the
+// request and in its response.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLen bounds the length of the IDs accepted from clients.
+const maxRequestIDLen = 128
+
+// RequestID returns a middleware giving each request an ID. The one sent by
+// the client, e.g. a proxy, is kept if it is a token of reasonable length,
+// otherwise a random one is generated. The ID is stored in the request's
+// context, where GetRequestID finds it, replaces its X-Request-Id fields for
+// handlers forwarding the request, and is sent back in the response.
+func RequestID() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			id := req.Headers.Get(RequestIDHeader)
+			if len(id) > maxRequestIDLen || !headers.IsToken(id) {
+				id = newRequestID()
+			}
+			req.Headers.Set(RequestIDHeader, id)
+			w.Header().Set(RequestIDHeader, id)
+
+			ctx := context.WithValue(req.Context(), requestIDKey{}, id)
+			next(w, req.WithContext(ctx))
+		}
+	}
+}
+
+// requestIDKey is the context key of request IDs.
+type requestIDKey struct{}
+
+// GetRequestID returns the ID given to req by the RequestID middleware, or
+// "" if there is none.
+func GetRequestID(req *request.Request) string {
+	return RequestIDFromContext(req.Context())
+}
+
+// RequestIDFromContext returns the request ID stored in ctx by the RequestID
+// middleware, for code that only has the context of the request.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
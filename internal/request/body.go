@@ -0,0 +1,124 @@
+package request
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrBodyClosed = errors.New("read on closed request body")
+
+// body reads a request body lazily from the connection, decoding the
+// chunked transfer coding if needed.
+type body struct {
+	parser  *Parser
+	request *Request
+	closed  bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyClosed
+	}
+
+	n, err := b.parser.readBody(b.request, p)
+	if f := b.request.onBodyComplete; f != nil && b.request.state == stateDone {
+		b.request.onBodyComplete = nil
+		f()
+	}
+
+	return n, err
+}
+
+// Close stops the body from being read any further. What is left of it is
+// still discarded from the connection by Parser.DiscardBody.
+func (b *body) Close() error {
+	b.closed = true
+	return nil
+}
+
+// BodyComplete reports whether the whole body of request has been read from
+// the connection, which is the case as soon as ReadRequest returns for
+// requests without a body.
+func (p *Parser) BodyComplete(request *Request) bool {
+	return request.state == stateDone
+}
+
+// OnBodyComplete calls f once the whole body of request has been read from
+// the connection: right away if it already is, or from the Read of Body that
+// reaches its end. Bodies read by DiscardBody don't call it.
+func (p *Parser) OnBodyComplete(request *Request, f func()) {
+	if p.BodyComplete(request) {
+		f()
+		return
+	}
+	request.onBodyComplete = f
+}
+
+// DiscardBody reads and throws away what is left of the body of request so
+// the next request on the connection can be read. It gives up after maxBytes
+// bytes and returns ErrBodyTooLarge, in which case the connection cannot be
+// reused.
+func (p *Parser) DiscardBody(request *Request, maxBytes int) error {
+	buf := make([]byte, bufferSize*64)
+	discarded := 0
+	for {
+		n, err := p.readBody(request, buf)
+		discarded += n
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if discarded > maxBytes {
+			return ErrBodyTooLarge
+		}
+	}
+}
+
+// readBody reads the next body bytes of request into dst. Buffered bytes are
+// used first, the rest is read straight from the connection.
+func (p *Parser) readBody(request *Request, dst []byte) (int, error) {
+	if len(dst) == 0 {
+		return 0, nil
+	}
+
+	for {
+		if request.state == stateDone {
+			return 0, io.EOF
+		}
+
+		if !request.readingData() {
+			// chunk size lines, chunk delimiters and trailers
+			err := p.parseUntil(request, func() bool {
+				return request.state == stateDone || request.readingData()
+			})
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		dst = dst[:min(len(dst), request.dataRemaining)]
+		if p.readToIndex > 0 {
+			n := copy(dst, p.buf[:p.readToIndex])
+			copy(p.buf, p.buf[n:p.readToIndex])
+			p.readToIndex -= n
+			request.consumeData(n)
+
+			return n, nil
+		}
+
+		n, err := p.reader.Read(dst)
+		request.consumeData(n)
+		if n > 0 {
+			return n, nil
+		}
+		if errors.Is(err, io.EOF) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
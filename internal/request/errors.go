@@ -0,0 +1,57 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rousage/httpfromtcp/internal/headers"
+)
+
+var (
+	ErrMalformedRequestLine      = errors.New("malformed request line")
+	ErrUnsupportedMethod         = errors.New("unsupported method")
+	ErrInvalidTarget             = errors.New("invalid request target")
+	ErrUnsupportedVersion        = errors.New("unsupported http version")
+	ErrBadContentLength          = errors.New("invalid content-length")
+	ErrBadTransferEncoding       = errors.New("invalid transfer-encoding")
+	ErrUnsupportedTransferCoding = errors.New("unsupported transfer coding")
+	ErrAmbiguousFraming          = errors.New("ambiguous message framing")
+	ErrMalformedChunk            = errors.New("malformed chunk")
+	ErrRequestLineTooLong        = errors.New("request line too long")
+	ErrHeaderTooLarge            = errors.New("request header fields too large")
+	ErrBodyTooLarge              = errors.New("request body too large")
+)
+
+// ParseError is returned for requests that break the HTTP/1.1 syntax or the
+// parser limits. Err wraps one of the sentinel errors of this package or of
+// the headers package, so callers can match it with errors.Is.
+type ParseError struct {
+	Err error
+	// Offset is the position of the offending byte from the start of the
+	// request line
+	Offset int
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// parseErrorAt returns err as a *ParseError at the given offset, relative to
+// the data it was found in. Offsets already carried by err are kept.
+func parseErrorAt(err error, offset int) *ParseError {
+	var pErr *ParseError
+	if errors.As(err, &pErr) {
+		return &ParseError{Err: pErr.Err, Offset: offset + pErr.Offset}
+	}
+
+	var hErr *headers.ParseError
+	if errors.As(err, &hErr) {
+		return &ParseError{Err: hErr.Err, Offset: offset + hErr.Offset}
+	}
+
+	return &ParseError{Err: err, Offset: offset}
+}
@@ -0,0 +1,60 @@
+package request
+
+import (
+	"bytes"
+)
+
+// maxChunkSizeLineBytes bounds a chunk-size line, including extensions
+const maxChunkSizeLineBytes = 4096
+
+// maxLeadingEmptyLines bounds the empty lines skipped before a request line
+const maxLeadingEmptyLines = 8
+
+// maxChunkSizeDigits bounds the hex digits of a chunk size, enough for any
+// size that fits in an int64
+const maxChunkSizeDigits = 16
+
+// Limits bounds the size of a request so a single client cannot make the
+// server allocate unbounded memory. A zero field means no limit.
+type Limits struct {
+	// MaxRequestLineBytes is the maximum length of the request line
+	MaxRequestLineBytes int
+	// MaxHeaderLineBytes is the maximum length of a single header field line
+	MaxHeaderLineBytes int
+	// MaxHeaderBytes is the maximum length of all header and trailer field
+	// lines combined
+	MaxHeaderBytes int
+	// MaxHeaderCount is the maximum number of header and trailer fields
+	MaxHeaderCount int
+	// MaxBodyBytes is the maximum length of the decoded body
+	MaxBodyBytes int
+}
+
+var DefaultLimits = Limits{
+	MaxRequestLineBytes: 8 << 10,
+	MaxHeaderLineBytes:  8 << 10,
+	MaxHeaderBytes:      64 << 10,
+	MaxHeaderCount:      100,
+	MaxBodyBytes:        10 << 20,
+}
+
+// exceedsLineLimit reports whether the line at the start of data is, or
+// will be once it is complete, longer than limit bytes.
+func exceedsLineLimit(data []byte, limit int) bool {
+	return limit > 0 && lineLength(data) > limit
+}
+
+// lineLength returns the length of the line at the start of data without
+// its line ending, or the length received so far if the line is incomplete.
+func lineLength(data []byte) int {
+	idx := bytes.IndexByte(data, '\n')
+	if idx == -1 {
+		idx = len(data)
+	}
+
+	// the last byte may be the CR of a line ending
+	if idx > 0 && data[idx-1] == '\r' {
+		return idx - 1
+	}
+	return idx
+}
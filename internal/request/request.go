@@ -1,89 +1,246 @@
 package request
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"io"
+	"net/url"
 	"strconv"
 	"strings"
 
 	"github.com/rousage/httpfromtcp/internal/headers"
 )
 
-const crlf = "\r\n"
 const bufferSize = 8
 const (
 	stateInitialized = iota
 	stateParsingHeaders
 	stateParsingBody
+	stateParsingContent
+	stateParsingChunkSize
+	stateParsingChunkData
+	stateParsingChunkDataEnd
+	stateParsingTrailers
 	stateDone
 )
 
 type RequestLine struct {
+	// HttpVersion is the version without the "HTTP/" prefix, e.g. "1.1"
 	HttpVersion   string
 	RequestTarget string
-	Method        string
+	// URL is RequestTarget parsed. Path holds the decoded path and
+	// EscapedPath the raw one, Query the decoded query parameters. For
+	// CONNECT only Host is set, and the asterisk-form of OPTIONS has "*" as
+	// Path.
+	URL    *url.URL
+	Method string
 }
+
+// IsHTTP10 reports whether the request was sent with HTTP/1.0, whose
+// connections are not persistent by default and which has no chunked
+// transfer coding.
+func (rl RequestLine) IsHTTP10() bool {
+	return rl.HttpVersion == "1.0"
+}
+
 type Request struct {
 	RequestLine RequestLine
-	Headers     headers.Headers
-	Body        []byte
-	state       int
+	Headers     *headers.Headers
+	// Body streams the request body from the connection as it is read. It
+	// is never nil and returns io.EOF right away for requests without one.
+	Body io.ReadCloser
+	// Trailers holds the trailer fields sent after the last chunk of a
+	// chunked body. They are only available once Body has returned io.EOF.
+	Trailers *headers.Headers
+	// Close is set when the connection must be closed after this request
+	// because its framing cannot be trusted.
+	Close bool
+	// RemoteAddr is the address of the client, set by the server.
+	RemoteAddr string
+	// TLS is the state of the TLS connection the request was received on,
+	// or nil for plain connections. It is set by the server.
+	TLS *tls.ConnectionState
+	// pathValues holds the wildcards matched by a router
+	pathValues map[string]string
+	// ctx is returned by Context, nil meaning context.Background()
+	ctx context.Context
+	// onBodyComplete is called by Body once it read the end of the body
+	onBodyComplete func()
+	state          int
+	// dataRemaining is the number of body bytes left in the current chunk,
+	// or in the whole body when it is delimited by Content-Length
+	dataRemaining int
+	// bodyBytes is the number of body bytes read so far
+	bodyBytes int
+	// offset is the number of bytes of the request consumed so far, used to
+	// locate parse errors
+	offset int
+	// lenient relaxes the parsing rules, see Parser.Lenient
+	lenient bool
+
+	limits      Limits
+	emptyLines  int
+	headerBytes int
+	headerCount int
+	// contentLengthAt and transferEncodingAt are the offsets of the first
+	// Content-Length and Transfer-Encoding fields, to locate framing errors
+	contentLengthAt    int
+	transferEncodingAt int
 }
 
+// Parser reads consecutive requests from a single connection. Bytes read
+// past the end of one request are kept for the next one, so pipelined
+// requests are not lost between calls to ReadRequest.
+type Parser struct {
+	// Limits bounds the size of the requests read by the parser
+	Limits Limits
+	// Lenient accepts lines ended by a bare LF, obsolete line folding, and
+	// Transfer-Encoding sent along with Content-Length or in HTTP/1.0, in
+	// which case chunked wins and Request.Close is set. Requests are parsed
+	// strictly by default, as recipients that disagree on these are how
+	// requests get smuggled.
+	// https://datatracker.ietf.org/doc/html/rfc9112#name-request-smuggling
+	Lenient bool
+
+	reader      io.Reader
+	buf         []byte
+	readToIndex int
+}
+
+func NewParser(reader io.Reader) *Parser {
+	return &Parser{
+		Limits: DefaultLimits,
+		reader: reader,
+		buf:    make([]byte, bufferSize),
+	}
+}
+
+// RequestFromReader reads a single request, including its whole body,
+// which is buffered in memory.
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize)
-	readToIndex := 0
+	request, err := NewParser(reader).ReadRequest()
+	if err != nil {
+		return nil, err
+	}
 
-	request := &Request{
-		state:   stateInitialized,
-		Headers: headers.NewHeaders(),
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		return nil, err
 	}
+	request.Body = io.NopCloser(bytes.NewReader(body))
+
+	return request, nil
+}
 
-	for request.state != stateDone {
-		if readToIndex >= len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf[:readToIndex])
-			buf = newBuf
+// WaitForRequest blocks until at least one byte of the next request is
+// available, without parsing it. It lets callers tell an idle connection
+// apart from one in the middle of sending a request.
+func (p *Parser) WaitForRequest() error {
+	for p.readToIndex == 0 {
+		if err := p.fill(); err != nil {
+			return err
 		}
+	}
 
-		numBytesRead, err := reader.Read(buf[readToIndex:])
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				request.state = stateDone
-				return request, err
-			}
+	return nil
+}
 
-			return nil, err
-		}
-		readToIndex += numBytesRead
+// ReadRequest parses the request line and headers of the next request from
+// the connection. Its body is read lazily from the connection through
+// Request.Body, and must be consumed or discarded with DiscardBody before
+// the next request can be read.
+//
+// It returns io.EOF if the connection was closed before any byte of a new
+// request was received, and io.ErrUnexpectedEOF if it was closed in the
+// middle of one.
+func (p *Parser) ReadRequest() (*Request, error) {
+	request := &Request{
+		state:    stateInitialized,
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		limits:   p.Limits,
+		lenient:  p.Lenient,
+	}
+	request.Body = &body{parser: p, request: request}
+
+	err := p.parseUntil(request, func() bool { return request.state > stateParsingHeaders })
+	if err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
 
-		numBytesParsed, err := request.parse(buf[:readToIndex])
+func (p *Parser) parseUntil(request *Request, done func() bool) error {
+	for {
+		// Parse whatever is already buffered first, it may hold a whole
+		// pipelined request left over from the previous call
+		numBytesParsed, err := request.parse(p.buf[:p.readToIndex])
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		if numBytesParsed > 0 {
 			// Remove the parsed data from the buffer
-			copy(buf, buf[numBytesParsed:readToIndex])
-			readToIndex -= numBytesParsed
+			copy(p.buf, p.buf[numBytesParsed:p.readToIndex])
+			p.readToIndex -= numBytesParsed
+		}
+
+		if done() {
+			return nil
+		}
+
+		if err := p.fill(); err != nil {
+			if errors.Is(err, io.EOF) {
+				if request.state == stateInitialized && p.readToIndex == 0 {
+					return io.EOF
+				}
+
+				return io.ErrUnexpectedEOF
+			}
+
+			return err
 		}
 	}
+}
 
-	return request, nil
+// fill reads more data from the connection into the buffer, growing it if
+// it is full.
+func (p *Parser) fill() error {
+	if p.readToIndex >= len(p.buf) {
+		newBuf := make([]byte, len(p.buf)*2)
+		copy(newBuf, p.buf[:p.readToIndex])
+		p.buf = newBuf
+	}
+
+	numBytesRead, err := p.reader.Read(p.buf[p.readToIndex:])
+	p.readToIndex += numBytesRead
+	// Data that came with an error is parsed first, the error will be
+	// returned again by the next read
+	if numBytesRead > 0 {
+		return nil
+	}
+
+	return err
 }
 
 func (r *Request) parse(data []byte) (int, error) {
 	totalBytesParsed := 0
-	for r.state != stateDone {
+	// Body data is not parsed, it is copied straight to the reader of Body
+	for r.state != stateDone && !r.readingData() {
+		prevState := r.state
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
-			return 0, err
+			return 0, parseErrorAt(err, r.offset)
 		}
-		// zero bytes parsed and no error = needs more data
-		if n == 0 {
+		// zero bytes parsed, no state change and no error = needs more data
+		if n == 0 && r.state == prevState {
 			return totalBytesParsed, nil
 		}
 		totalBytesParsed += n
+		r.offset += n
 	}
 
 	return totalBytesParsed, nil
@@ -92,7 +249,21 @@ func (r *Request) parse(data []byte) (int, error) {
 func (r *Request) parseSingle(data []byte) (int, error) {
 	switch r.state {
 	case stateInitialized:
-		requestLine, bytesParsed, err := parseRequestLine(data)
+		// Some clients send a stray CRLF after a request body, which must not
+		// make the next request on the connection fail
+		// https://datatracker.ietf.org/doc/html/rfc9112#name-message-format
+		if lineLen, n, err := headers.NextLine(data, r.lenient); err == nil && n > 0 && lineLen == 0 {
+			r.emptyLines++
+			if r.emptyLines > maxLeadingEmptyLines {
+				return 0, ErrMalformedRequestLine
+			}
+			return n, nil
+		}
+		if exceedsLineLimit(data, r.limits.MaxRequestLineBytes) {
+			return 0, &ParseError{Err: ErrRequestLineTooLong, Offset: r.limits.MaxRequestLineBytes}
+		}
+
+		requestLine, bytesParsed, err := parseRequestLine(data, r.lenient)
 		if err != nil {
 			return 0, err
 		}
@@ -107,7 +278,7 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		return bytesParsed, nil
 
 	case stateParsingHeaders:
-		bytesParsed, done, err := r.Headers.Parse(data)
+		bytesParsed, done, err := r.parseFieldLine(r.Headers, data)
 		if err != nil {
 			return 0, err
 		}
@@ -122,25 +293,96 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		return bytesParsed, nil
 
 	case stateParsingBody:
-		contentLengthHeader := r.Headers.Get("Content-Length")
-		if contentLengthHeader == "" {
+		// Transfer-Encoding takes precedence over Content-Length
+		// https://datatracker.ietf.org/doc/html/rfc9112#name-message-body-length
+		if len(r.Headers.Values("Transfer-Encoding")) > 0 {
+			if err := r.checkTransferEncoding(); err != nil {
+				return 0, err
+			}
+			r.state = stateParsingChunkSize
+			return 0, nil
+		}
+
+		contentLengths := r.Headers.Values("Content-Length")
+		if len(contentLengths) == 0 {
 			r.state = stateDone
 			return 0, nil
 		}
-		contentLength, err := strconv.Atoi(contentLengthHeader)
+		contentLength, ok := parseContentLength(contentLengths)
+		if !ok {
+			return 0, r.fieldError(ErrBadContentLength, r.contentLengthAt)
+		}
+		if r.limits.MaxBodyBytes > 0 && contentLength > r.limits.MaxBodyBytes {
+			return 0, ErrBodyTooLarge
+		}
+
+		if contentLength == 0 {
+			r.state = stateDone
+		} else {
+			r.dataRemaining = contentLength
+			r.state = stateParsingContent
+		}
+
+		return 0, nil
+
+	case stateParsingChunkSize:
+		if exceedsLineLimit(data, maxChunkSizeLineBytes) {
+			return 0, &ParseError{Err: ErrMalformedChunk, Offset: maxChunkSizeLineBytes}
+		}
+
+		chunkSize, bytesParsed, err := parseChunkSize(data, r.lenient)
 		if err != nil {
 			return 0, err
 		}
+		// zero bytes parsed and no error = needs more data
+		if bytesParsed == 0 {
+			return 0, nil
+		}
+		// Compared this way round, a huge chunk size cannot overflow the sum
+		if r.limits.MaxBodyBytes > 0 && chunkSize > r.limits.MaxBodyBytes-r.bodyBytes {
+			return 0, ErrBodyTooLarge
+		}
 
-		r.Body = append(r.Body, data...)
-		if len(r.Body) > contentLength {
-			return 0, errors.New("error: body is longer than content-length")
+		if chunkSize == 0 {
+			r.state = stateParsingTrailers
+		} else {
+			r.dataRemaining = chunkSize
+			r.state = stateParsingChunkData
 		}
-		if len(r.Body) == contentLength {
+
+		return bytesParsed, nil
+
+	case stateParsingChunkDataEnd:
+		// every chunk's data is followed by a CRLF
+		lineLen, bytesParsed, err := headers.NextLine(data, r.lenient)
+		if err != nil {
+			return 0, err
+		}
+		// zero bytes parsed and no error = needs more data
+		if bytesParsed == 0 {
+			return 0, nil
+		}
+		if lineLen != 0 {
+			return 0, ErrMalformedChunk
+		}
+		r.state = stateParsingChunkSize
+
+		return bytesParsed, nil
+
+	case stateParsingTrailers:
+		bytesParsed, done, err := r.parseFieldLine(r.Trailers, data)
+		if err != nil {
+			return 0, err
+		}
+		// zero bytes parsed and no error = needs more data
+		if bytesParsed == 0 {
+			return 0, nil
+		}
+		if done {
 			r.state = stateDone
 		}
 
-		return len(data), nil
+		return bytesParsed, nil
 
 	case stateDone:
 		return 0, errors.New("error: trying to read data in a done state")
@@ -150,45 +392,292 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	}
 }
 
-func parseRequestLine(request []byte) (RequestLine, int, error) {
-	requestStr := string(request)
-	// if \r\n is not in the string, it needs more data
-	if !strings.Contains(requestStr, crlf) {
+// Context returns the context of the request. For requests received by the
+// server, it is cancelled when the client goes away, when the response is
+// due or when the server closes. It is never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx,
+// e.g. to pass values down to the next handlers. The copy shares the body
+// of r.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r2 := new(Request)
+	*r2 = *r
+	r2.ctx = ctx
+
+	return r2
+}
+
+// PathValue returns the value of the named path wildcard matched by a
+// router, or "" if there is none.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+// SetPathValue sets the value of a path wildcard, as matched by a router.
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = make(map[string]string)
+	}
+	r.pathValues[name] = value
+}
+
+// readingData reports whether the parser is in the middle of the body data,
+// either of a chunk or of a body delimited by Content-Length.
+func (r *Request) readingData() bool {
+	return r.state == stateParsingContent || r.state == stateParsingChunkData
+}
+
+// consumeData records that n bytes of body data have been read.
+func (r *Request) consumeData(n int) {
+	r.dataRemaining -= n
+	r.bodyBytes += n
+	r.offset += n
+	if r.dataRemaining > 0 {
+		return
+	}
+
+	if r.state == stateParsingChunkData {
+		r.state = stateParsingChunkDataEnd
+	} else {
+		r.state = stateDone
+	}
+}
+
+// parseFieldLine parses a single header or trailer field line into hs,
+// enforcing the header limits.
+func (r *Request) parseFieldLine(hs *headers.Headers, data []byte) (int, bool, error) {
+	if exceedsLineLimit(data, r.limits.MaxHeaderLineBytes) {
+		return 0, false, &ParseError{Err: ErrHeaderTooLarge, Offset: r.limits.MaxHeaderLineBytes}
+	}
+	if r.limits.MaxHeaderBytes > 0 && r.headerBytes+lineLength(data) > r.limits.MaxHeaderBytes {
+		return 0, false, ErrHeaderTooLarge
+	}
+
+	parse := hs.Parse
+	if r.lenient {
+		parse = hs.ParseLenient
+	}
+	bytesParsed, done, err := parse(data)
+	if err != nil || bytesParsed == 0 || done {
+		return bytesParsed, done, err
+	}
+
+	if hs == r.Headers {
+		r.recordFramingField(data[:bytesParsed])
+	}
+	r.headerBytes += bytesParsed
+	r.headerCount++
+	if r.limits.MaxHeaderCount > 0 && r.headerCount > r.limits.MaxHeaderCount {
+		return 0, false, ErrHeaderTooLarge
+	}
+
+	return bytesParsed, done, nil
+}
+
+// recordFramingField records the offset of line if it is the first
+// Content-Length or Transfer-Encoding field.
+func (r *Request) recordFramingField(line []byte) {
+	name, _, _ := bytes.Cut(line, []byte(":"))
+	switch {
+	case r.contentLengthAt == 0 && strings.EqualFold(string(name), "Content-Length"):
+		r.contentLengthAt = r.offset
+	case r.transferEncodingAt == 0 && strings.EqualFold(string(name), "Transfer-Encoding"):
+		r.transferEncodingAt = r.offset
+	}
+}
+
+// fieldError returns err located at the start of the header field found at
+// offset. Errors found once the header section is parsed would otherwise
+// point at its end, as parse locates them from the current offset.
+func (r *Request) fieldError(err error, offset int) *ParseError {
+	return &ParseError{Err: err, Offset: offset - r.offset}
+}
+
+// checkTransferEncoding enforces the framing rules for a request with a
+// Transfer-Encoding: chunked must be the final coding and be applied only
+// once, and Content-Length must not be sent along with it. Chunked is the
+// only coding supported.
+// https://datatracker.ietf.org/doc/html/rfc9112#name-transfer-encoding
+func (r *Request) checkTransferEncoding() error {
+	var codings []string
+	for _, value := range r.Headers.Values("Transfer-Encoding") {
+		for coding := range strings.SplitSeq(value, ",") {
+			if coding = strings.Trim(coding, " \t"); coding != "" {
+				codings = append(codings, coding)
+			}
+		}
+	}
+
+	chunkedCount := 0
+	for _, coding := range codings {
+		if strings.EqualFold(coding, "chunked") {
+			chunkedCount++
+		}
+	}
+	if chunkedCount != 1 || !strings.EqualFold(codings[len(codings)-1], "chunked") {
+		return r.fieldError(ErrBadTransferEncoding, r.transferEncodingAt)
+	}
+	// The other codings, e.g. gzip, would have to be decoded for the handler
+	// to get the body it expects
+	if len(codings) > 1 {
+		return r.fieldError(ErrUnsupportedTransferCoding, r.transferEncodingAt)
+	}
+
+	// HTTP/1.0 has no transfer codings, and a Content-Length sent along is
+	// how requests get smuggled past proxies that disagree on which wins.
+	// Lenient parsing lets chunked win, but the connection can't be reused.
+	ambiguous := r.RequestLine.IsHTTP10() || len(r.Headers.Values("Content-Length")) > 0
+	if ambiguous {
+		if !r.lenient {
+			return r.fieldError(ErrAmbiguousFraming, r.transferEncodingAt)
+		}
+		r.Headers.Del("Content-Length")
+		r.Close = true
+	}
+
+	return nil
+}
+
+// parseChunkSize parses a chunk-size line, ignoring any chunk extensions.
+// https://datatracker.ietf.org/doc/html/rfc9112#name-chunked-transfer-coding
+func parseChunkSize(data []byte, lenient bool) (int, int, error) {
+	lineLen, n, err := headers.NextLine(data, lenient)
+	if err != nil {
+		return 0, 0, err
+	}
+	// no line ending yet, it needs more data
+	if n == 0 {
+		return 0, 0, nil
+	}
+
+	chunkSizeLine := string(data[:lineLen])
+	// chunk extensions are separated from the size by a semicolon
+	sizeStr, _, _ := strings.Cut(chunkSizeLine, ";")
+	sizeStr = strings.TrimRight(sizeStr, " \t")
+	if !isHexDigits(sizeStr) || len(sizeStr) > maxChunkSizeDigits {
+		return 0, 0, ErrMalformedChunk
+	}
+
+	chunkSize, err := strconv.ParseInt(sizeStr, 16, 64)
+	if err != nil {
+		return 0, 0, ErrMalformedChunk
+	}
+
+	return int(chunkSize), n, nil
+}
+
+// isHexDigits reports whether value is a non-empty run of hex digits, as
+// ParseInt would also accept a sign.
+func isHexDigits(value string) bool {
+	if value == "" {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if !isHexDigit(value[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseContentLength parses the Content-Length fields of a request. Values
+// must be made of digits only, signs are not allowed. A list of identical
+// values, from repeated fields or a comma-separated list, is accepted as a
+// single one but differing values are rejected.
+// https://datatracker.ietf.org/doc/html/rfc9110#name-content-length
+func parseContentLength(values []string) (int, bool) {
+	contentLength := -1
+	for _, value := range values {
+		for v := range strings.SplitSeq(value, ",") {
+			n, ok := parseDigits(strings.Trim(v, " \t"))
+			if !ok {
+				return 0, false
+			}
+			if contentLength != -1 && n != contentLength {
+				return 0, false
+			}
+			contentLength = n
+		}
+	}
+
+	return contentLength, contentLength != -1
+}
+
+func parseDigits(value string) (int, bool) {
+	if value == "" {
+		return 0, false
+	}
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+func parseRequestLine(request []byte, lenient bool) (RequestLine, int, error) {
+	lineLen, bytesConsumed, err := headers.NextLine(request, lenient)
+	if err != nil {
+		return RequestLine{}, 0, err
+	}
+	// no line ending yet, it needs more data
+	if bytesConsumed == 0 {
 		return RequestLine{}, 0, nil
 	}
 
-	requestLineStr := strings.Split(requestStr, crlf)[0]
+	requestLineStr := string(request[:lineLen])
 	if requestLineStr == "" {
-		return RequestLine{}, 0, errors.New("empty request line")
+		return RequestLine{}, 0, ErrMalformedRequestLine
 	}
 
 	parts := strings.Split(requestLineStr, " ")
 	if len(parts) != 3 {
-		return RequestLine{}, 0, errors.New("invalid request line")
+		return RequestLine{}, 0, ErrMalformedRequestLine
 	}
 
 	var (
 		method        = parts[0]
 		requestTarget = parts[1]
 		httpVersion   = parts[2]
+
+		targetOffset  = len(method) + 1
+		versionOffset = targetOffset + len(requestTarget) + 1
 	)
+	if !headers.IsToken(method) {
+		return RequestLine{}, 0, ErrMalformedRequestLine
+	}
 	if !isValidMethod(method) {
-		return RequestLine{}, 0, errors.New("invalid method")
+		return RequestLine{}, 0, ErrUnsupportedMethod
 	}
-	version, ok := parseHttpVersion(httpVersion)
+	targetURL, ok := parseRequestTarget(method, requestTarget)
 	if !ok {
-		return RequestLine{}, 0, errors.New("invalid http version")
+		return RequestLine{}, 0, &ParseError{Err: ErrInvalidTarget, Offset: targetOffset}
 	}
-	if requestTarget == "" {
-		return RequestLine{}, 0, errors.New("invalid request target")
+	version, err := parseHttpVersion(httpVersion)
+	if err != nil {
+		return RequestLine{}, 0, &ParseError{Err: err, Offset: versionOffset}
 	}
 
-	// Return the number of bytes consumed: length of request line + \r\n
-	bytesConsumed := len(requestLineStr) + len(crlf)
-
 	return RequestLine{
 		Method:        method,
 		RequestTarget: requestTarget,
+		URL:           targetURL,
 		HttpVersion:   version,
 	}, bytesConsumed, nil
 }
@@ -202,10 +691,22 @@ func isValidMethod(method string) bool {
 	}
 }
 
-func parseHttpVersion(httpVersion string) (string, bool) {
-	if httpVersion != "HTTP/1.1" {
-		return "", false
+// parseHttpVersion parses an HTTP-version, "HTTP/" DIGIT "." DIGIT.
+// Any HTTP/1.x version is accepted, other major versions are reported as
+// unsupported.
+// https://datatracker.ietf.org/doc/html/rfc9112#name-http-version
+func parseHttpVersion(httpVersion string) (string, error) {
+	version, ok := strings.CutPrefix(httpVersion, "HTTP/")
+	if !ok || len(version) != 3 || !isDigit(version[0]) || version[1] != '.' || !isDigit(version[2]) {
+		return "", ErrMalformedRequestLine
+	}
+	if version[0] != '1' {
+		return "", ErrUnsupportedVersion
 	}
 
-	return "1.1", true
+	return version, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
 }
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/rousage/httpfromtcp/internal/headers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -46,6 +47,17 @@ func TestRequestLineParse(t *testing.T) {
 	assert.Equal(t, "/coffee", r.RequestLine.RequestTarget)
 	assert.Equal(t, "1.1", r.RequestLine.HttpVersion)
 
+	// Test: Good HTTP/1.0 Request line
+	reader = &chunkReader{
+		data:            "GET /coffee HTTP/1.0\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	assert.True(t, r.RequestLine.IsHTTP10())
+
 	// Test: Invalid number of parts in request line
 	_, err = RequestFromReader(strings.NewReader("/coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"))
 	require.Error(t, err)
@@ -59,6 +71,13 @@ func TestRequestLineParse(t *testing.T) {
 	require.Error(t, err)
 }
 
+func readBody(t *testing.T, r *Request) string {
+	t.Helper()
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
 type chunkReader struct {
 	data            string
 	numBytesPerRead int
@@ -87,9 +106,9 @@ func TestRequestHeadersParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	assert.Equal(t, "curl/7.81.0", r.Headers.Get("user-agent"))
+	assert.Equal(t, "*/*", r.Headers.Get("accept"))
 
 	// Test: Empty Headers
 	reader = &chunkReader{
@@ -99,7 +118,7 @@ func TestRequestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Empty(t, r.Headers)
+	assert.Equal(t, 0, r.Headers.Len())
 
 	// Test: Duplicate Headers
 	reader = &chunkReader{
@@ -109,8 +128,8 @@ func TestRequestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "value-one, value-two", r.Headers["header"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, []string{"value-one", "value-two"}, r.Headers.Values("header"))
+	assert.Equal(t, "*/*", r.Headers.Get("accept"))
 
 	// Test: Case Insesitive Headers
 	reader = &chunkReader{
@@ -120,8 +139,8 @@ func TestRequestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "value-one, value-two", r.Headers["header"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, []string{"value-one", "value-two"}, r.Headers.Values("header"))
+	assert.Equal(t, "*/*", r.Headers.Get("accept"))
 
 	// Test: Missing End of Headers
 	reader = &chunkReader{
@@ -153,7 +172,7 @@ func TestRequestBodyParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, "hello world!\n", readBody(t, r))
 
 	// Test: Empty Body, 0 reported content length
 	reader = &chunkReader{
@@ -166,7 +185,7 @@ func TestRequestBodyParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, 0, len(r.Body))
+	assert.Equal(t, 0, len(readBody(t, r)))
 
 	// Test: Empty Body, no reported content length
 	reader = &chunkReader{
@@ -178,7 +197,7 @@ func TestRequestBodyParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, 0, len(r.Body))
+	assert.Equal(t, 0, len(readBody(t, r)))
 
 	// Test: Body shorter than reported content length
 	reader = &chunkReader{
@@ -203,5 +222,536 @@ func TestRequestBodyParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, 0, len(r.Body))
+	assert.Equal(t, 0, len(readBody(t, r)))
+}
+
+func TestRequestChunkedBodyParse(t *testing.T) {
+	// Test: Standard Chunked Body
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6\r\n" +
+			"hello \r\n" +
+			"7\r\n" +
+			"world!\n\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!\n", readBody(t, r))
+	assert.Equal(t, 0, r.Trailers.Len())
+
+	// Test: Chunk Extensions and Trailers
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"a;name=value\r\n" +
+			"0123456789\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "0123456789", readBody(t, r))
+	assert.Equal(t, "abc123", r.Trailers.Get("X-Checksum"))
+
+	// Test: Empty Chunked Body
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, 0, len(readBody(t, r)))
+
+	// Test: Invalid Chunk Size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\n" +
+			"hello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Signed Chunk Sizes
+	for _, size := range []string{"+3", "-0"} {
+		reader = &chunkReader{
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				size + "\r\n" +
+				"abc\r\n" +
+				"0\r\n" +
+				"\r\n",
+			numBytesPerRead: 3,
+		}
+		r, err = RequestFromReader(reader)
+		if err == nil {
+			_, err = io.ReadAll(r.Body)
+		}
+		require.ErrorIs(t, err, ErrMalformedChunk, size)
+	}
+
+	// Test: Chunk Data Longer Than Chunk Size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\n" +
+			"hello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Missing Terminating Chunk
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\n" +
+			"hello\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestParserPipelinedRequests(t *testing.T) {
+	// Test: Pipelined requests in a single read
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /next HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 1024,
+	}
+	p := NewParser(reader)
+	r, err := p.ReadRequest()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "/submit", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", readBody(t, r))
+
+	r, err = p.ReadRequest()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+	assert.Equal(t, "localhost:42069", r.Headers.Get("Host"))
+
+	// Test: Connection closed between requests
+	_, err = p.ReadRequest()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: Stray CRLF after a body
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello\r\n" +
+			"GET /next HTTP/1.1\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	p = NewParser(reader)
+	r, err = p.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", readBody(t, r))
+	r, err = p.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Too many empty lines before a request
+	p = NewParser(strings.NewReader(strings.Repeat("\r\n", 9) + "GET / HTTP/1.1\r\n\r\n"))
+	_, err = p.ReadRequest()
+	require.ErrorIs(t, err, ErrMalformedRequestLine)
+
+	// Test: Connection closed in the middle of a request
+	reader = &chunkReader{
+		data: "GET / HTTP/1.1\r\n" +
+			"\r\n" +
+			"GET / HTTP/1.1\r\n",
+		numBytesPerRead: 3,
+	}
+	p = NewParser(reader)
+	_, err = p.ReadRequest()
+	require.NoError(t, err)
+	_, err = p.ReadRequest()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestRequestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLineBytes: 32,
+		MaxHeaderLineBytes:  32,
+		MaxHeaderBytes:      64,
+		MaxHeaderCount:      3,
+		MaxBodyBytes:        8,
+	}
+	parse := func(data string) error {
+		p := NewParser(&chunkReader{data: data, numBytesPerRead: 3})
+		p.Limits = limits
+		r, err := p.ReadRequest()
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(r.Body)
+		return err
+	}
+
+	// Test: Within Limits
+	err := parse("POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello")
+	require.NoError(t, err)
+
+	// Test: Request Line Too Long
+	err = parse("GET /" + strings.Repeat("a", 64) + " HTTP/1.1\r\n\r\n")
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Request Line Too Long Without CRLF
+	err = parse("GET /" + strings.Repeat("a", 64))
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Header Line Too Long
+	err = parse("GET / HTTP/1.1\r\nX-Long: " + strings.Repeat("a", 32) + "\r\n\r\n")
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Total Header Bytes Too Large
+	err = parse("GET / HTTP/1.1\r\nX-One: " + strings.Repeat("a", 20) + "\r\nX-Two: " + strings.Repeat("a", 20) + "\r\nX-Three: " + strings.Repeat("a", 20) + "\r\n\r\n")
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Too Many Headers
+	err = parse("GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n")
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Content-Length Too Large
+	err = parse("POST / HTTP/1.1\r\nContent-Length: 9\r\n\r\n123456789")
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Chunked Body Too Large
+	err = parse("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n5\r\nworld\r\n0\r\n\r\n")
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Chunk Size Overflowing The Body Size
+	err = parse("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n1\r\na\r\n7fffffffffffffff\r\nhello\r\n")
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Chunk Size With Too Many Digits
+	err = parse("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" + strings.Repeat("0", 16) + "1\r\na\r\n0\r\n\r\n")
+	require.ErrorIs(t, err, ErrMalformedChunk)
+}
+
+func TestRequestStreamingBody(t *testing.T) {
+	// Test: Body is read lazily from the connection
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	p := NewParser(reader)
+	r, err := p.ReadRequest()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Less(t, reader.pos, len(reader.data))
+	assert.Equal(t, "hello world!\n", readBody(t, r))
+
+	// Test: The end of the body is notified once read from the connection
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\n" +
+			"hello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	}
+	p = NewParser(reader)
+	r, err = p.ReadRequest()
+	require.NoError(t, err)
+	completed := 0
+	p.OnBodyComplete(r, func() { completed++ })
+	assert.Equal(t, 0, completed)
+	assert.Equal(t, "hello", readBody(t, r))
+	assert.Equal(t, 1, completed)
+	assert.Equal(t, len(reader.data), reader.pos)
+
+	// Test: Unread body is discarded before the next request
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\n" +
+			"hello\r\n" +
+			"0\r\n" +
+			"\r\n" +
+			"GET /next HTTP/1.1\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	}
+	p = NewParser(reader)
+	r, err = p.ReadRequest()
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+	_, err = r.Body.Read(make([]byte, 1))
+	require.ErrorIs(t, err, ErrBodyClosed)
+	require.NoError(t, p.DiscardBody(r, 1024))
+	r, err = p.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Discarding gives up on large bodies
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	p = NewParser(reader)
+	r, err = p.ReadRequest()
+	require.NoError(t, err)
+	require.ErrorIs(t, p.DiscardBody(r, 4), ErrBodyTooLarge)
+}
+
+func TestRequestParseErrors(t *testing.T) {
+	parse := func(data string) error {
+		r, err := NewParser(strings.NewReader(data)).ReadRequest()
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(r.Body)
+		return err
+	}
+	offset := func(t *testing.T, err error) int {
+		t.Helper()
+		var pErr *ParseError
+		require.ErrorAs(t, err, &pErr)
+		return pErr.Offset
+	}
+
+	// Test: Malformed request line
+	err := parse("GET /coffee\r\n\r\n")
+	require.ErrorIs(t, err, ErrMalformedRequestLine)
+
+	// Test: Unsupported method
+	err = parse("BREW /coffee HTTP/1.1\r\n\r\n")
+	require.ErrorIs(t, err, ErrUnsupportedMethod)
+	assert.Equal(t, 0, offset(t, err))
+
+	// Test: Unsupported version
+	err = parse("GET /coffee HTTP/2.1\r\n\r\n")
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+	assert.Equal(t, 12, offset(t, err))
+
+	// Test: Unsupported major version
+	err = parse("GET /coffee HTTP/3.0\r\n\r\n")
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	// Test: Malformed version
+	err = parse("GET /coffee HTTP/one\r\n\r\n")
+	require.ErrorIs(t, err, ErrMalformedRequestLine)
+
+	// Test: Invalid header name, offset from the start of the request
+	err = parse("GET / HTTP/1.1\r\nHost: localhost\r\nB@d: value\r\n\r\n")
+	require.ErrorIs(t, err, headers.ErrInvalidFieldName)
+	assert.Equal(t, 34, offset(t, err))
+
+	// Test: Negative Content-Length
+	err = parse("POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n")
+	require.ErrorIs(t, err, ErrBadContentLength)
+
+	// Test: Malformed chunk
+	err = parse("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhelloX\r\n0\r\n\r\n")
+	require.ErrorIs(t, err, ErrMalformedChunk)
+	assert.Equal(t, 55, offset(t, err))
+}
+
+func TestRequestTargetParse(t *testing.T) {
+	parse := func(requestLine string) (*Request, error) {
+		return RequestFromReader(strings.NewReader(requestLine + "\r\nHost: localhost:42069\r\n\r\n"))
+	}
+
+	// Test: Origin-form with query
+	r, err := parse("GET /video%20clips/vim?quality=high&tag=a&tag=b%21 HTTP/1.1")
+	require.NoError(t, err)
+	assert.Equal(t, "/video clips/vim", r.RequestLine.URL.Path)
+	assert.Equal(t, "/video%20clips/vim", r.RequestLine.URL.EscapedPath())
+	assert.Equal(t, "high", r.RequestLine.URL.Query().Get("quality"))
+	assert.Equal(t, []string{"a", "b!"}, r.RequestLine.URL.Query()["tag"])
+
+	// Test: Encoded slash is kept in the raw path
+	r, err = parse("GET /files/a%2Fb HTTP/1.1")
+	require.NoError(t, err)
+	assert.Equal(t, "/files/a/b", r.RequestLine.URL.Path)
+	assert.Equal(t, "/files/a%2Fb", r.RequestLine.URL.EscapedPath())
+
+	// Test: Absolute-form
+	r, err = parse("GET http://example.com:8080/coffee?x=1 HTTP/1.1")
+	require.NoError(t, err)
+	assert.Equal(t, "http", r.RequestLine.URL.Scheme)
+	assert.Equal(t, "example.com:8080", r.RequestLine.URL.Host)
+	assert.Equal(t, "/coffee", r.RequestLine.URL.Path)
+	assert.Equal(t, "1", r.RequestLine.URL.Query().Get("x"))
+
+	// Test: Authority-form
+	r, err = parse("CONNECT example.com:443 HTTP/1.1")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:443", r.RequestLine.URL.Host)
+
+	// Test: Asterisk-form
+	r, err = parse("OPTIONS * HTTP/1.1")
+	require.NoError(t, err)
+	assert.Equal(t, "*", r.RequestLine.URL.Path)
+
+	// Test: Asterisk-form on another method
+	_, err = parse("GET * HTTP/1.1")
+	require.ErrorIs(t, err, ErrInvalidTarget)
+
+	// Test: Authority-form without port
+	_, err = parse("CONNECT example.com HTTP/1.1")
+	require.ErrorIs(t, err, ErrInvalidTarget)
+
+	// Test: Malformed percent-encoding in path
+	_, err = parse("GET /coffee%zz HTTP/1.1")
+	require.ErrorIs(t, err, ErrInvalidTarget)
+
+	// Test: Malformed percent-encoding in query
+	_, err = parse("GET /coffee?size=%g HTTP/1.1")
+	require.ErrorIs(t, err, ErrInvalidTarget)
+	_, err = parse("GET /coffee?size=%4 HTTP/1.1")
+	require.ErrorIs(t, err, ErrInvalidTarget)
+
+	// Test: Semicolons in query
+	r, err = parse("GET /a?x=1;y=2 HTTP/1.1")
+	require.NoError(t, err)
+	assert.Equal(t, "x=1;y=2", r.RequestLine.URL.RawQuery)
+
+	// Test: Relative target
+	_, err = parse("GET coffee HTTP/1.1")
+	require.ErrorIs(t, err, ErrInvalidTarget)
+}
+
+func TestRequestFraming(t *testing.T) {
+	parse := func(data string, lenient bool) (*Request, string, error) {
+		p := NewParser(strings.NewReader(data))
+		p.Lenient = lenient
+		r, err := p.ReadRequest()
+		if err != nil {
+			return nil, "", err
+		}
+		body, err := io.ReadAll(r.Body)
+		return r, string(body), err
+	}
+
+	// Test: Identical Content-Length values are accepted
+	_, body, err := parse("POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 5, 5\r\n\r\nhello", false)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", body)
+
+	// Test: Differing Content-Length values
+	_, _, err = parse("POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!", false)
+	require.ErrorIs(t, err, ErrBadContentLength)
+	var pErr *ParseError
+	require.ErrorAs(t, err, &pErr)
+	assert.Equal(t, 17, pErr.Offset)
+	_, _, err = parse("POST / HTTP/1.1\r\nContent-Length: 5, 6\r\n\r\nhello!", true)
+	require.ErrorIs(t, err, ErrBadContentLength)
+
+	// Test: Signed Content-Length
+	_, _, err = parse("POST / HTTP/1.1\r\nContent-Length: +5\r\n\r\nhello", false)
+	require.ErrorIs(t, err, ErrBadContentLength)
+
+	// Test: Transfer-Encoding with Content-Length
+	data := "POST / HTTP/1.1\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n"
+	_, _, err = parse(data, false)
+	require.ErrorIs(t, err, ErrAmbiguousFraming)
+	require.ErrorAs(t, err, &pErr)
+	assert.Equal(t, 36, pErr.Offset)
+	r, body, err := parse(data, true)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", body)
+	assert.True(t, r.Close)
+	assert.Equal(t, "", r.Headers.Get("Content-Length"))
+
+	// Test: Transfer-Encoding in HTTP/1.0
+	_, _, err = parse("POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", false)
+	require.ErrorIs(t, err, ErrAmbiguousFraming)
+
+	// Test: Chunked is not the final coding
+	_, _, err = parse("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked, gzip\r\n\r\n", true)
+	require.ErrorIs(t, err, ErrBadTransferEncoding)
+	require.ErrorAs(t, err, &pErr)
+	assert.Equal(t, 34, pErr.Offset)
+
+	// Test: Codings other than chunked are not supported
+	_, _, err = parse("POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n", false)
+	require.ErrorIs(t, err, ErrUnsupportedTransferCoding)
+	_, _, err = parse("POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", true)
+	require.ErrorIs(t, err, ErrUnsupportedTransferCoding)
+
+	// Test: Chunked applied twice
+	_, _, err = parse("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n\r\n", true)
+	require.ErrorIs(t, err, ErrBadTransferEncoding)
+
+	// Test: Whitespace before colon
+	_, _, err = parse("POST / HTTP/1.1\r\nTransfer-Encoding : chunked\r\n\r\n", true)
+	require.ErrorIs(t, err, headers.ErrInvalidFieldName)
+
+	// Test: Bare LF
+	data = "POST / HTTP/1.1\nHost: localhost\nContent-Length: 5\n\nhello"
+	_, _, err = parse(data, false)
+	require.ErrorIs(t, err, headers.ErrBareLF)
+	r, body, err = parse(data, true)
+	require.NoError(t, err)
+	assert.Equal(t, "localhost", r.Headers.Get("Host"))
+	assert.Equal(t, "hello", body)
+
+	// Test: LF smuggled inside a field value
+	_, _, err = parse("POST / HTTP/1.1\r\nX-Note: a\nTransfer-Encoding: chunked\r\n\r\n", false)
+	require.ErrorIs(t, err, headers.ErrBareLF)
+
+	// Test: Obsolete line folding
+	data = "GET / HTTP/1.1\r\nX-Folded: one\r\n  two\r\n\r\n"
+	_, _, err = parse(data, false)
+	require.ErrorIs(t, err, headers.ErrObsFold)
+	r, _, err = parse(data, true)
+	require.NoError(t, err)
+	assert.Equal(t, "one two", r.Headers.Get("X-Folded"))
+
+	// Test: Whitespace before the first field line
+	_, _, err = parse("GET / HTTP/1.1\r\n Host: localhost\r\n\r\n", true)
+	require.ErrorIs(t, err, headers.ErrObsFold)
 }
@@ -0,0 +1,68 @@
+package request
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// parseRequestTarget parses a request-target in any of its four forms.
+// https://datatracker.ietf.org/doc/html/rfc9112#name-request-target
+func parseRequestTarget(method, target string) (*url.URL, bool) {
+	// asterisk-form, only for a server-wide OPTIONS
+	if target == "*" {
+		if method != "OPTIONS" {
+			return nil, false
+		}
+		return &url.URL{Path: "*"}, true
+	}
+
+	// authority-form, only for CONNECT
+	if method == "CONNECT" {
+		host, port, err := net.SplitHostPort(target)
+		if err != nil || host == "" || port == "" {
+			return nil, false
+		}
+		return &url.URL{Host: target}, true
+	}
+
+	// A request-target never carries a fragment
+	if strings.Contains(target, "#") {
+		return nil, false
+	}
+
+	// origin-form or absolute-form, malformed percent-encoding is rejected
+	u, err := url.ParseRequestURI(target)
+	if err != nil {
+		return nil, false
+	}
+	if !validPercentEncoding(u.RawQuery) {
+		return nil, false
+	}
+	if !strings.HasPrefix(target, "/") && (u.Scheme == "" || u.Host == "") {
+		return nil, false
+	}
+
+	return u, true
+}
+
+// validPercentEncoding reports whether every % in s starts a %XX escape.
+// Unlike url.ParseQuery, it leaves the query's own syntax alone, e.g. ";"
+// separators are fine.
+func validPercentEncoding(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			continue
+		}
+		if i+2 >= len(s) || !isHexDigit(s[i+1]) || !isHexDigit(s[i+2]) {
+			return false
+		}
+		i += 2
+	}
+
+	return true
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,42 @@
+package response
+
+import "fmt"
+
+// ErrorRenderer writes a complete error response with the given status code
+// and message, e.g. as a JSON or HTML document. It is called on a Writer in
+// the status line state.
+type ErrorRenderer func(w *Writer, statusCode StatusCode, message string) error
+
+// SetErrorRenderer changes how WriteError renders error responses.
+func (w *Writer) SetErrorRenderer(renderer ErrorRenderer) {
+	w.errorRenderer = renderer
+}
+
+// WriteError writes a complete error response using the writer's
+// ErrorRenderer, or RenderTextError if none was set.
+func (w *Writer) WriteError(statusCode StatusCode, message string) error {
+	if w.errorRenderer != nil {
+		return w.errorRenderer(w, statusCode, message)
+	}
+
+	return RenderTextError(w, statusCode, message)
+}
+
+// RenderTextError renders an error as a plain text body holding the message,
+// or the reason phrase if the message is empty.
+func RenderTextError(w *Writer, statusCode StatusCode, message string) error {
+	if message == "" {
+		message = StatusText(statusCode)
+	}
+	body := fmt.Appendf(nil, "%d %s\n", statusCode, message)
+
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(GetDefaultHeaders(len(body))); err != nil {
+		return err
+	}
+
+	_, err := w.WriteBody(body)
+	return err
+}
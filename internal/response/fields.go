@@ -0,0 +1,59 @@
+package response
+
+import (
+	"fmt"
+
+	"github.com/rousage/httpfromtcp/internal/headers"
+)
+
+// FieldError is returned by WriteHeaders and WriteTrailers for a field that
+// would corrupt the response if written, e.g. a value holding CR or LF
+// copied from user input, which could be used to split the response. Err is
+// headers.ErrInvalidFieldName or headers.ErrInvalidFieldValue.
+type FieldError struct {
+	Err  error
+	Name string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%v in field %q", e.Err, e.Name)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// validateFields checks every field of hs against the syntax of field names
+// and values.
+func validateFields(hs *headers.Headers) error {
+	for name, value := range hs.All() {
+		if !headers.IsToken(name) {
+			return &FieldError{Err: headers.ErrInvalidFieldName, Name: name}
+		}
+		if !headers.IsValidValue(value) {
+			return &FieldError{Err: headers.ErrInvalidFieldValue, Name: name}
+		}
+	}
+
+	return nil
+}
+
+// validateContentLength checks that Content-Length, if set, is a single
+// plain number, as the client relies on it to find the end of the body.
+func validateContentLength(hs *headers.Headers) error {
+	values := hs.Values("Content-Length")
+	if len(values) == 0 {
+		return nil
+	}
+	err := &FieldError{Err: headers.ErrInvalidFieldValue, Name: "Content-Length"}
+	if len(values) > 1 || values[0] == "" {
+		return err
+	}
+	for i := 0; i < len(values[0]); i++ {
+		if values[0][i] < '0' || values[0][i] > '9' {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,21 +1,16 @@
 package response
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 
 	"github.com/rousage/httpfromtcp/internal/headers"
 )
 
-type StatusCode int
-
-const (
-	StatusOK                  StatusCode = 200
-	StatusBadRequest          StatusCode = 400
-	StatusInternalServerError StatusCode = 500
-)
 const (
 	stateStatusLine = iota
 	stateHeaders
@@ -23,111 +18,648 @@ const (
 	stateDone
 )
 
-var codeToReasonPhrase = map[StatusCode]string{
-	StatusOK:                  "OK",
-	StatusBadRequest:          "Bad Request",
-	StatusInternalServerError: "Internal Server Error",
-}
+// writeBufferSize is the size of the buffer in front of the connection.
+const writeBufferSize = 4 << 10
+
+// bodyBufferSize is how much of a body is buffered, when the handler didn't
+// set its framing, before it is sent with chunked encoding.
+const bodyBufferSize = 4 << 10
+
+var (
+	// ErrBodyNotAllowed is returned when writing a body in a response whose
+	// status code doesn't allow one: 1xx, 204 and 304.
+	ErrBodyNotAllowed = errors.New("response status does not allow a body")
+	// ErrContentLength is returned when the body written doesn't match the
+	// Content-Length set by the handler.
+	ErrContentLength = errors.New("body length does not match Content-Length")
+	// ErrTrailersNotAllowed is returned when writing trailers after a body
+	// that isn't chunked.
+	ErrTrailersNotAllowed = errors.New("trailers require a chunked body")
+)
 
+// Writer writes a response in order: the status line, the headers, the
+// body and, for chunked bodies, the trailers.
+//
+// When the headers have neither Content-Length nor Transfer-Encoding, the
+// body is framed automatically: it is buffered, and sent with a
+// Content-Length once it is done, or with chunked encoding if it outgrows
+// the buffer or Flush is called. Finish must then be called to complete the
+// response, which the server does once the handler returns.
 type Writer struct {
-	io.Writer
+	// out buffers the writes to dst, the connection, so that a response
+	// is sent in as few packets as possible
+	out        *bufio.Writer
+	dst        *sentWriter
 	writeState int
+	statusCode StatusCode
+	// closeConn is set when the connection must be closed once the
+	// response has been written
+	closeConn bool
+	// closing reports whether the connection is about to be closed anyway,
+	// e.g. by a server shutting down. It is checked when the headers are
+	// sent.
+	closing       func() bool
+	errorRenderer ErrorRenderer
+	// http10 is set when the client speaks HTTP/1.0, which has no chunked
+	// encoding and closes connections by default
+	http10 bool
+	// chunked is set when the body is sent with chunked encoding, while
+	// chunkedBody is set when the headers announce it, even if it can't be
+	// used with the client
+	chunked     bool
+	chunkedBody bool
+	// contentLength is the Content-Length set by the handler, or -1
+	contentLength int
+	// noBody is set when the status code doesn't allow a body
+	noBody bool
+	// head is set when answering a HEAD request, whose response has the
+	// headers of a GET one but no body
+	head bool
+	// pending holds the headers until the framing of the body is known,
+	// while buf holds the body written so far, buffered bytes long. buf is
+	// left empty for HEAD requests.
+	pending  *headers.Headers
+	buf      []byte
+	buffered int
+	// trailersDue is set once the last chunk is written, until the trailer
+	// section is ended, and trailersWritten once WriteTrailers succeeded
+	trailersDue     bool
+	trailersWritten bool
+	// aborted is set when the response was abandoned halfway
+	aborted bool
+	// header holds fields added to the headers passed to WriteHeaders
+	header *headers.Headers
+	// bodyBytes is the number of body bytes written, without framing
+	bodyBytes int
+}
+
+// Flusher is implemented by writers that can send what was written so far
+// right away, e.g. to stream events to the client.
+type Flusher interface {
+	Flush() error
 }
 
+var _ Flusher = (*Writer)(nil)
+
+// NewWriter returns a Writer writing a response to w. Writes are buffered
+// until Flush or Finish is called.
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{w, stateStatusLine}
+	dst := &sentWriter{w: w}
+	return &Writer{
+		out:           bufio.NewWriterSize(dst, writeBufferSize),
+		dst:           dst,
+		writeState:    stateStatusLine,
+		contentLength: -1,
+		header:        headers.NewHeaders(),
+	}
+}
+
+// sentWriter records whether anything was written to w.
+type sentWriter struct {
+	w    io.Writer
+	sent bool
+}
+
+func (sw *sentWriter) Write(p []byte) (int, error) {
+	sw.sent = sw.sent || len(p) > 0
+	return sw.w.Write(p)
+}
+
+// Committed reports whether part of the response has left the writer's
+// buffer for the connection, either flushed or because the buffer was full.
+// The response can't be replaced by another one from then on.
+func (w *Writer) Committed() bool {
+	return w.dst.sent
+}
+
+// Discard drops the response written so far so that another one can be
+// written instead, e.g. an error response, and reports whether it could. It
+// can't once the response is committed. Fields added with Header and what
+// was learnt about the request are kept.
+func (w *Writer) Discard() bool {
+	if w.Committed() {
+		return false
+	}
+
+	w.out.Reset(w.dst)
+	w.writeState = stateStatusLine
+	w.statusCode = 0
+	w.chunked = false
+	w.chunkedBody = false
+	w.contentLength = -1
+	w.noBody = false
+	w.pending = nil
+	w.buf = nil
+	w.buffered = 0
+	w.trailersDue = false
+	w.trailersWritten = false
+	w.bodyBytes = 0
+
+	return true
+}
+
+// Header returns fields that are sent along with the ones passed to
+// WriteHeaders, unless a field with the same name is passed there. It lets
+// code running before the handler, such as a router, add response headers.
+func (w *Writer) Header() *headers.Headers {
+	return w.header
+}
+
+// CloseConnection marks the connection to be closed after this response.
+// If called before WriteHeaders, "Connection: close" is sent to the client.
+func (w *Writer) CloseConnection() {
+	w.closeConn = true
+}
+
+// SetClosing sets a function checked right before the header section is
+// sent. If it reports true, the connection is closed after this response and
+// "Connection: close" is sent, e.g. when the server started shutting down
+// while the handler was running.
+func (w *Writer) SetClosing(closing func() bool) {
+	w.closing = closing
+}
+
+// SetRequestVersion tells the writer which HTTP version the client used,
+// e.g. "1.0", so the response framing can be adapted to it. The status line
+// always announces HTTP/1.1, the highest version the server supports.
+// https://datatracker.ietf.org/doc/html/rfc9110#name-protocol-version
+func (w *Writer) SetRequestVersion(version string) {
+	w.http10 = version == "1.0"
+}
+
+// SetRequestMethod tells the writer which method the client used. For HEAD
+// requests the headers are sent as for GET, including the Content-Length
+// computed by the writer, but the body written by the handler is discarded.
+// https://datatracker.ietf.org/doc/html/rfc9110#name-head
+func (w *Writer) SetRequestMethod(method string) {
+	w.head = method == "HEAD"
 }
 
+// ShouldClose reports whether the connection must be closed after this
+// response, either because CloseConnection was called, the handler sent
+// "Connection: close" or the body is delimited by closing the connection.
+func (w *Writer) ShouldClose() bool {
+	return w.closeConn
+}
+
+// StatusCode returns the status code sent with WriteStatusLine, or 0 if the
+// status line has not been written yet.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// BodyBytes returns the number of body bytes written so far, not counting
+// the chunked encoding framing.
+func (w *Writer) BodyBytes() int {
+	return w.bodyBytes
+}
+
+// WriteStatusLine writes the status line with the standard reason phrase of
+// statusCode, which is left empty for unregistered codes. An
+// informational (1xx) status line is followed by its header section, then
+// by the status line of the final response.
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineWithReason(statusCode, StatusText(statusCode))
+}
+
+// WriteStatusLineWithReason writes the status line with a custom reason
+// phrase. Clients are not supposed to rely on it, but some display it.
+func (w *Writer) WriteStatusLineWithReason(statusCode StatusCode, reason string) error {
 	if w.writeState != stateStatusLine {
 		return errors.New("state is not status line")
 	}
+	if statusCode < 100 || statusCode > 999 {
+		return fmt.Errorf("invalid status code %d", statusCode)
+	}
+	// HTTP/1.0 has no informational responses
+	if statusCode < 200 && w.http10 {
+		return fmt.Errorf("status code %d cannot be sent to an HTTP/1.0 client", statusCode)
+	}
+	if !isValidReason(reason) {
+		return fmt.Errorf("invalid reason phrase %q", reason)
+	}
 	w.writeState = stateHeaders
+	w.statusCode = statusCode
 
-	reasonPhrase := codeToReasonPhrase[statusCode]
-
-	if _, err := io.WriteString(w, fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, reasonPhrase)); err != nil {
+	if _, err := io.WriteString(w.out, fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, reason)); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (w *Writer) WriteHeaders(hs headers.Headers) error {
+// WriteHeaders writes the header section. If hs has no Content-Length nor
+// Transfer-Encoding, the headers are held until the writer knows how to
+// frame the body. Content-Length and Transfer-Encoding are dropped from the
+// responses that cannot have a body, and Content-Length from chunked ones.
+// A field that is not valid, including a Content-Length that is not a
+// number, is returned as a *FieldError.
+func (w *Writer) WriteHeaders(hs *headers.Headers) error {
 	if w.writeState != stateHeaders {
 		return errors.New("state is not headers")
 	}
+	hs = w.mergeHeader(hs)
+	// Nothing is written if a field is invalid, so the handler can still
+	// send another response
+	if err := validateFields(hs); err != nil {
+		return err
+	}
+	if err := validateContentLength(hs); err != nil {
+		return err
+	}
 	w.writeState = stateBody
 
-	for k, v := range hs {
-		if _, err := io.WriteString(w, fmt.Sprintf("%s: %s\r\n", k, v)); err != nil {
-			return err
+	// A sender must not send Content-Length along with chunked encoding
+	// https://datatracker.ietf.org/doc/html/rfc9112#name-content-length
+	if hs.HasToken("Transfer-Encoding", "chunked") {
+		hs.Del("Content-Length")
+	}
+
+	if hs.HasToken("Connection", "close") {
+		w.closeConn = true
+	}
+
+	// https://datatracker.ietf.org/doc/html/rfc9110#name-content-length
+	w.noBody = w.statusCode < 200 || w.statusCode == StatusNoContent || w.statusCode == StatusNotModified
+	if w.noBody {
+		if w.statusCode != StatusNotModified {
+			hs.Del("Content-Length")
+		}
+		hs.Del("Transfer-Encoding")
+		if w.statusCode < 200 {
+			return w.writeInterim(hs)
 		}
+		return w.writeHeaderSection(hs)
+	}
+
+	if hs.Get("Content-Length") == "" && len(hs.Values("Transfer-Encoding")) == 0 {
+		w.pending = hs
+		return nil
 	}
 
-	_, err := io.WriteString(w, "\r\n")
+	return w.writeHeaderSection(hs)
+}
+
+// writeInterim writes the header section of an informational (1xx)
+// response, after which the final response is written from its status line.
+// https://datatracker.ietf.org/doc/html/rfc9110#name-informational-1xx
+func (w *Writer) writeInterim(hs *headers.Headers) error {
+	w.writeState = stateStatusLine
+	w.statusCode = 0
+	w.noBody = false
+
+	for k, v := range hs.All() {
+		if _, err := io.WriteString(w.out, fmt.Sprintf("%s: %s\r\n", k, v)); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w.out, "\r\n")
 
 	return err
 }
 
-func (w *Writer) WriteTrailers(hs headers.Headers) error {
-	if w.writeState != stateDone {
-		return errors.New("state is not done")
+// writeHeaderSection writes hs, whose framing headers are final.
+func (w *Writer) writeHeaderSection(hs *headers.Headers) error {
+	// HTTP/1.0 clients don't know chunked encoding, the body is sent as is
+	// and delimited by closing the connection instead
+	w.chunkedBody = hs.HasToken("Transfer-Encoding", "chunked")
+	w.chunked = w.chunkedBody && !w.http10
+	if n, err := strconv.Atoi(hs.Get("Content-Length")); err == nil && !w.chunkedBody && !w.noBody {
+		w.contentLength = n
+	}
+	// Without Content-Length or chunked encoding the client can only find
+	// the end of the body when the connection is closed
+	if !w.hasFraming(hs) || (w.closing != nil && w.closing()) {
+		w.closeConn = true
 	}
 
-	for k, v := range hs {
-		if _, err := io.WriteString(w, fmt.Sprintf("%s: %s\r\n", k, v)); err != nil {
+	for k, v := range hs.All() {
+		if w.http10 && (strings.EqualFold(k, "Transfer-Encoding") || strings.EqualFold(k, "Trailer")) {
+			continue
+		}
+		if _, err := io.WriteString(w.out, fmt.Sprintf("%s: %s\r\n", k, v)); err != nil {
+			return err
+		}
+	}
+	if hs.Get("Connection") == "" {
+		if err := w.writeConnectionHeader(); err != nil {
 			return err
 		}
 	}
 
-	_, err := io.WriteString(w, "\r\n")
+	_, err := io.WriteString(w.out, "\r\n")
 
 	return err
 }
 
-func (w *Writer) WriteBody(body []byte) (int, error) {
+// mergeHeader returns a copy of hs with the fields of w.Header() whose name
+// is not in hs appended.
+func (w *Writer) mergeHeader(hs *headers.Headers) *headers.Headers {
+	merged := headers.NewHeaders()
+	for k, v := range hs.All() {
+		merged.Add(k, v)
+	}
+	for k, v := range w.header.All() {
+		if hs.Get(k) == "" {
+			merged.Add(k, v)
+		}
+	}
+
+	return merged
+}
+
+// writeConnectionHeader announces whether the connection stays open when the
+// handler didn't, as far as it differs from the client's version default.
+func (w *Writer) writeConnectionHeader() error {
+	var err error
+	switch {
+	case w.closeConn:
+		_, err = io.WriteString(w.out, "Connection: close\r\n")
+	case w.http10:
+		// HTTP/1.0 connections are closed unless the server says otherwise
+		_, err = io.WriteString(w.out, "Connection: keep-alive\r\n")
+	}
+
+	return err
+}
+
+// hasFraming reports whether the client can find the end of the body
+// without the connection being closed.
+// https://datatracker.ietf.org/doc/html/rfc9112#name-message-body-length
+func (w *Writer) hasFraming(hs *headers.Headers) bool {
+	if w.noBody || w.head {
+		return true
+	}
+	if hs.Get("Content-Length") != "" {
+		return true
+	}
+	return w.chunked
+}
+
+// sendPending writes the held headers and the buffered body. If the body is
+// complete, it is framed with Content-Length, otherwise with chunked
+// encoding, or by closing the connection for HTTP/1.0 clients.
+func (w *Writer) sendPending(complete bool) error {
+	hs := w.pending
+	w.pending = nil
+	if complete {
+		hs.Set("Content-Length", fmt.Sprintf("%d", w.buffered))
+	} else {
+		hs.Set("Transfer-Encoding", "chunked")
+	}
+	if err := w.writeHeaderSection(hs); err != nil {
+		return err
+	}
+
+	buf := w.buf
+	w.buf = nil
+	w.buffered = 0
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.writeBody(buf)
+	return err
+}
+
+// buffer holds p until the framing of the body is known.
+func (w *Writer) buffer(p []byte) {
+	w.buffered += len(p)
+	if !w.head {
+		w.buf = append(w.buf, p...)
+	}
+}
+
+// writeBody writes p to the connection with the framing of the response.
+func (w *Writer) writeBody(p []byte) (int, error) {
+	if w.head {
+		return len(p), nil
+	}
+	if !w.chunked {
+		if w.contentLength >= 0 && w.bodyBytes+len(p) > w.contentLength {
+			return 0, ErrContentLength
+		}
+		n, err := w.out.Write(p)
+		w.bodyBytes += n
+		return n, err
+	}
+	// An empty chunk would end the body
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if _, err := io.WriteString(w.out, fmt.Sprintf("%x\r\n", len(p))); err != nil {
+		return 0, err
+	}
+	n, err := w.out.Write(p)
+	w.bodyBytes += n
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(w.out, "\r\n")
+	return n, err
+}
+
+// endBody marks the end of the body: the held headers are sent with the
+// whole body, or the last chunk is written.
+func (w *Writer) endBody() error {
+	w.writeState = stateDone
+	if w.pending != nil {
+		return w.sendPending(true)
+	}
+	if w.chunked && !w.head {
+		w.trailersDue = true
+		_, err := io.WriteString(w.out, "0\r\n")
+		return err
+	}
+
+	return nil
+}
+
+// Write writes body bytes, making Writer an io.Writer once the headers are
+// written. It can be called any number of times.
+func (w *Writer) Write(p []byte) (int, error) {
 	if w.writeState != stateBody {
 		return 0, errors.New("state is not body")
 	}
+	if w.noBody {
+		return 0, ErrBodyNotAllowed
+	}
+	if w.pending != nil {
+		if w.buffered+len(p) <= bodyBufferSize {
+			w.buffer(p)
+			return len(p), nil
+		}
+		if err := w.sendPending(false); err != nil {
+			return 0, err
+		}
+	}
+
+	return w.writeBody(p)
+}
+
+// Flush sends what was written so far to the client right away. If the
+// framing of the body was left to the writer and the body isn't done yet,
+// it switches to chunked encoding.
+func (w *Writer) Flush() error {
+	if w.writeState == stateBody && w.pending != nil {
+		if err := w.sendPending(false); err != nil {
+			return err
+		}
+	}
+	return w.out.Flush()
+}
+
+// Abort abandons the response, e.g. after the handler panicked halfway
+// through it. What was already written is sent, nothing more is, and the
+// connection is closed so the client can tell the response is incomplete.
+// A response whose header section is not complete yet is dropped instead,
+// as the client could not make sense of it.
+func (w *Writer) Abort() {
+	if w.writeState >= stateBody && w.pending == nil {
+		w.out.Flush()
+	} else {
+		w.out.Reset(w.dst)
+	}
+
+	w.aborted = true
+	w.closeConn = true
+	w.pending = nil
+	w.buf = nil
+	w.buffered = 0
 	w.writeState = stateDone
+	w.trailersDue = false
+}
+
+// Finish completes the response and flushes it to the client. What the
+// handler left out is filled in: a 200 status line, an empty header
+// section, the held headers and buffered body, the last chunk and the
+// trailer section of a chunked body. A body shorter than its Content-Length
+// cannot be completed, so ErrContentLength is returned and the connection
+// marked to be closed.
+func (w *Writer) Finish() error {
+	if w.aborted {
+		return nil
+	}
+	if w.writeState == stateStatusLine {
+		if err := w.WriteStatusLine(StatusOK); err != nil {
+			return err
+		}
+	}
+	if w.writeState == stateHeaders {
+		if err := w.WriteHeaders(headers.NewHeaders()); err != nil {
+			return err
+		}
+	}
+	if w.writeState == stateBody {
+		if err := w.endBody(); err != nil {
+			return err
+		}
+	}
+	if w.trailersDue {
+		w.trailersDue = false
+		if _, err := io.WriteString(w.out, "\r\n"); err != nil {
+			return err
+		}
+	}
+	if err := w.out.Flush(); err != nil {
+		return err
+	}
 
-	return w.Write(body)
+	if !w.head && w.bodyBytes < w.contentLength {
+		w.closeConn = true
+		return ErrContentLength
+	}
+	return nil
 }
 
-func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+// WriteTrailers writes the trailer section after the last chunk of a chunked
+// body, which must have been announced in the headers. The trailers are
+// dropped for HTTP/1.0 clients and HEAD requests, which don't get chunks.
+func (w *Writer) WriteTrailers(hs *headers.Headers) error {
+	if w.writeState != stateDone {
+		return errors.New("state is not done")
+	}
+	if !w.chunkedBody {
+		return ErrTrailersNotAllowed
+	}
+	if w.trailersWritten {
+		return errors.New("trailers already written")
+	}
+	if err := validateFields(hs); err != nil {
+		return err
+	}
+	w.trailersWritten = true
+	if !w.trailersDue {
+		return nil
+	}
+	w.trailersDue = false
+
+	for k, v := range hs.All() {
+		if _, err := io.WriteString(w.out, fmt.Sprintf("%s: %s\r\n", k, v)); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w.out, "\r\n")
+
+	return err
+}
+
+// WriteBody writes the whole body at once. If the framing was left to the
+// writer, it is sent with a Content-Length.
+func (w *Writer) WriteBody(body []byte) (int, error) {
 	if w.writeState != stateBody {
 		return 0, errors.New("state is not body")
 	}
-
-	n, err := io.WriteString(w, fmt.Sprintf("%x\r\n", len(p)))
-	if err != nil {
-		return n, err
+	if w.noBody && len(body) > 0 {
+		return 0, ErrBodyNotAllowed
+	}
+	if w.pending != nil {
+		// The body is complete, so its length is known without copying it
+		// into the buffer: the held headers and the bytes buffered so far
+		// are sent first, followed by body
+		w.buffered += len(body)
+		if err := w.sendPending(true); err != nil {
+			return 0, err
+		}
 	}
 
-	n2, err := w.Write(p)
+	n, err := w.writeBody(body)
 	if err != nil {
-		return n + n2, err
+		return n, err
 	}
+	return n, w.endBody()
+}
 
-	n3, err := io.WriteString(w, "\r\n")
-	return n + n2 + n3, err
+// WriteChunkedBody writes a chunk of the body. It is the same as Write.
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	return w.Write(p)
 }
 
+// WriteChunkedBodyDone ends the body, writing the last chunk of a chunked
+// body. WriteTrailers can be called next.
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	if w.writeState != stateBody {
 		return 0, errors.New("state is not body")
 	}
-	w.writeState = stateDone
+	return 0, w.endBody()
+}
 
-	return w.Write([]byte("0\r\n"))
+// GetDefaultHeaders returns the headers of a plain text response with a body
+// of contentLen bytes.
+func GetDefaultHeaders(contentLen int) *headers.Headers {
+	hs := headers.NewHeaders()
+	hs.Set("Content-Length", fmt.Sprintf("%d", contentLen))
+	hs.Set("Content-Type", "text/plain")
+
+	return hs
 }
 
-func GetDefaultHeaders(contentLen int) headers.Headers {
+// DefaultHeaders returns the headers of a plain text response whose body is
+// framed by the writer.
+func DefaultHeaders() *headers.Headers {
 	hs := headers.NewHeaders()
-	hs[strings.ToLower("Content-Length")] = fmt.Sprintf("%d", contentLen)
-	hs[strings.ToLower("Connection")] = "close"
-	hs[strings.ToLower("Content-Type")] = "text/plain"
+	hs.Set("Content-Type", "text/plain")
 
 	return hs
 }
@@ -0,0 +1,536 @@
+package response
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rousage/httpfromtcp/internal/headers"
+)
+
+func TestStatusLine(t *testing.T) {
+	// Test: Standard reason phrase
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusTooManyRequests))
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 429 Too Many Requests\r\n", buf.String())
+	assert.Equal(t, StatusTooManyRequests, w.StatusCode())
+
+	// Test: Unregistered code
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(299))
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 299 \r\n", buf.String())
+
+	// Test: Custom reason phrase
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLineWithReason(StatusOK, "All Good"))
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 200 All Good\r\n", buf.String())
+
+	// Test: Invalid status lines are not written
+	buf.Reset()
+	w = NewWriter(&buf)
+	assert.Error(t, w.WriteStatusLineWithReason(StatusOK, "OK\r\nSet-Cookie: a=1"))
+	assert.Error(t, w.WriteStatusLine(42))
+	assert.Error(t, w.WriteStatusLine(1000))
+	require.NoError(t, w.Flush())
+	assert.Empty(t, buf.String())
+	require.NoError(t, w.WriteStatusLine(StatusServiceUnavailable))
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 503 Service Unavailable\r\n", buf.String())
+}
+
+func TestStatusText(t *testing.T) {
+	assert.Equal(t, "Created", StatusText(StatusCreated))
+	assert.Equal(t, "Not Modified", StatusText(StatusNotModified))
+	assert.Equal(t, "Unprocessable Content", StatusText(StatusUnprocessableContent))
+	assert.Equal(t, "", StatusText(418))
+}
+
+func TestAutomaticFraming(t *testing.T) {
+	// Test: Small bodies get a Content-Length
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(DefaultHeaders()))
+	_, err := w.Write([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("world"))
+	require.NoError(t, err)
+	assert.Empty(t, buf.String())
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 11\r\n\r\nhello world", buf.String())
+	assert.False(t, w.ShouldClose())
+
+	// Test: A complete body is sent after the buffered bytes, not copied
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(DefaultHeaders()))
+	_, err = w.Write([]byte("head "))
+	require.NoError(t, err)
+	large := bytes.Repeat([]byte("a"), 4*bodyBufferSize)
+	n, err := w.WriteBody(large)
+	require.NoError(t, err)
+	assert.Equal(t, len(large), n)
+	assert.Nil(t, w.buf)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n\r\nhead %s", 5+len(large), large), buf.String())
+	assert.False(t, w.ShouldClose())
+
+	// Test: Large bodies switch to chunked encoding
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(DefaultHeaders()))
+	_, err = w.Write(bytes.Repeat([]byte("a"), bodyBufferSize))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("b"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"1000\r\n"+strings.Repeat("a", bodyBufferSize)+"\r\n1\r\nb\r\n0\r\n\r\n", buf.String())
+	assert.Equal(t, bodyBufferSize+1, w.BodyBytes())
+
+	// Test: Flush switches to chunked encoding
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(DefaultHeaders()))
+	_, err = w.Write([]byte("event"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nevent\r\n", buf.String())
+
+	// Test: HTTP/1.0 bodies are delimited by closing the connection
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetRequestVersion("1.0")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(DefaultHeaders()))
+	_, err = w.Write([]byte("event"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\nevent", buf.String())
+	assert.True(t, w.ShouldClose())
+
+	// Test: Responses without a body
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	_, err = w.Write([]byte("ignored"))
+	require.ErrorIs(t, err, ErrBodyNotAllowed)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\nContent-Type: text/plain\r\n\r\n", buf.String())
+
+	// Test: Aborted responses are left incomplete
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+	_, err = w.Write([]byte("partial"))
+	require.NoError(t, err)
+	w.Abort()
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\nContent-Type: text/plain\r\n\r\npartial", buf.String())
+	assert.True(t, w.ShouldClose())
+
+	// Test: Aborted responses without a complete header section are dropped
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(DefaultHeaders()))
+	_, err = w.Write([]byte("partial"))
+	require.NoError(t, err)
+	w.Abort()
+	require.NoError(t, w.Finish())
+	assert.Empty(t, buf.String())
+	assert.True(t, w.ShouldClose())
+}
+
+func TestDiscard(t *testing.T) {
+	// Test: A response still in the buffer can be replaced
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err := w.Write([]byte("hel"))
+	require.NoError(t, err)
+	assert.False(t, w.Committed())
+	require.True(t, w.Discard())
+	require.NoError(t, w.WriteError(StatusInternalServerError, ""))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error\r\nContent-Length: 26\r\nContent-Type: text/plain\r\n\r\n500 Internal Server Error\n", buf.String())
+
+	// Test: A flushed response is committed
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	require.NoError(t, w.Flush())
+	assert.True(t, w.Committed())
+	assert.False(t, w.Discard())
+
+	// Test: So is one that outgrew the buffer
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(2*writeBufferSize)))
+	_, err = w.Write(bytes.Repeat([]byte("a"), 2*writeBufferSize))
+	require.NoError(t, err)
+	assert.True(t, w.Committed())
+}
+
+func TestInformationalResponse(t *testing.T) {
+	// Test: The final response follows an interim one
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusContinue))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.Equal(t, StatusCode(0), w.StatusCode())
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(2)))
+	_, err := w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\n\r\nok", buf.String())
+	assert.False(t, w.ShouldClose())
+
+	// Test: A final response is sent if the handler stops after the interim one
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusEarlyHints))
+	hs := headers.NewHeaders()
+	hs.Set("Link", "</style.css>; rel=preload")
+	require.NoError(t, w.WriteHeaders(hs))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", buf.String())
+
+	// Test: HTTP/1.0 clients don't get interim responses
+	w = NewWriter(&buf)
+	w.SetRequestVersion("1.0")
+	assert.Error(t, w.WriteStatusLine(StatusContinue))
+}
+
+func TestHeadResponse(t *testing.T) {
+	// Test: The Content-Length of the GET response is sent without the body
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetRequestMethod("HEAD")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(DefaultHeaders()))
+	n, err := w.WriteBody([]byte("hello world"))
+	require.NoError(t, err)
+	assert.Equal(t, 11, n)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 11\r\n\r\n", buf.String())
+	assert.False(t, w.ShouldClose())
+
+	// Test: Chunked bodies and trailers are dropped
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetRequestMethod("HEAD")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	hs := DefaultHeaders()
+	hs.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(hs))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(DefaultHeaders()))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n", buf.String())
+	assert.False(t, w.ShouldClose())
+}
+
+func TestWriteError(t *testing.T) {
+	// Test: Plain text rendering
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.CloseConnection()
+	require.NoError(t, w.WriteError(StatusBadRequest, "missing Host"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\nContent-Length: 17\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\n400 missing Host\n", buf.String())
+
+	// Test: The reason phrase stands in for an empty message
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteError(StatusNotFound, ""))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\nContent-Length: 14\r\nContent-Type: text/plain\r\n\r\n404 Not Found\n", buf.String())
+
+	// Test: Custom renderer
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetErrorRenderer(func(w *Writer, statusCode StatusCode, message string) error {
+		body := fmt.Sprintf(`{"error":%q}`, message)
+		hs := GetDefaultHeaders(len(body))
+		hs.Set("Content-Type", "application/json")
+		w.WriteStatusLine(statusCode)
+		w.WriteHeaders(hs)
+		_, err := w.WriteBody([]byte(body))
+		return err
+	})
+	require.NoError(t, w.WriteError(StatusUnprocessableContent, "short and stout"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 422 Unprocessable Content\r\nContent-Length: 27\r\nContent-Type: application/json\r\n\r\n{\"error\":\"short and stout\"}", buf.String())
+}
+
+func TestInvalidFields(t *testing.T) {
+	// Test: A value splitting the response is rejected and nothing written
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusFound))
+	hs := GetDefaultHeaders(0)
+	hs.Set("Location", "/home\r\nSet-Cookie: session=evil")
+	err := w.WriteHeaders(hs)
+	var fErr *FieldError
+	require.ErrorAs(t, err, &fErr)
+	assert.ErrorIs(t, err, headers.ErrInvalidFieldValue)
+	assert.Equal(t, "Location", fErr.Name)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 302 Found\r\n", buf.String())
+
+	// Test: Headers can be written again once fixed
+	hs.Set("Location", "/home")
+	require.NoError(t, w.WriteHeaders(hs))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 302 Found\r\nContent-Length: 0\r\nContent-Type: text/plain\r\nLocation: /home\r\n\r\n", buf.String())
+
+	// Test: Content-Length must be a number
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	hs = DefaultHeaders()
+	hs.Set("Content-Length", "abc")
+	err = w.WriteHeaders(hs)
+	require.ErrorAs(t, err, &fErr)
+	assert.Equal(t, "Content-Length", fErr.Name)
+	hs.Set("Content-Length", "-1")
+	assert.ErrorIs(t, w.WriteHeaders(hs), headers.ErrInvalidFieldValue)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String())
+
+	// Test: Content-Length is dropped from chunked responses
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	hs = GetDefaultHeaders(5)
+	hs.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(hs))
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n", buf.String())
+
+	// Test: Invalid names
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	hs = GetDefaultHeaders(0)
+	hs.Set("X Bad", "1")
+	assert.ErrorIs(t, w.WriteHeaders(hs), headers.ErrInvalidFieldName)
+
+	// Test: Invalid trailers are rejected and nothing written
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	hs = DefaultHeaders()
+	hs.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(hs))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc\nX-Injected: 1")
+	err = w.WriteTrailers(trailers)
+	require.ErrorAs(t, err, &fErr)
+	assert.ErrorIs(t, err, headers.ErrInvalidFieldValue)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n0\r\n\r\n"), buf.String())
+	assert.NotContains(t, buf.String(), "X-Injected")
+}
+
+func TestUnfinishedResponse(t *testing.T) {
+	// Test: A handler writing nothing gets an empty 200
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", buf.String())
+	assert.False(t, w.ShouldClose())
+
+	// Test: Missing headers are completed
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n\r\n", buf.String())
+
+	// Test: A chunked body gets its last chunk and trailer section
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	hs := DefaultHeaders()
+	hs.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(hs))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n", buf.String())
+	assert.False(t, w.ShouldClose())
+
+	// Test: A body shorter than its Content-Length breaks the connection
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+	_, err = w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, w.Finish(), ErrContentLength)
+	assert.True(t, w.ShouldClose())
+
+	// Test: A body longer than its Content-Length is rejected
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(3)))
+	_, err = w.WriteBody([]byte("hello"))
+	assert.ErrorIs(t, err, ErrContentLength)
+}
+
+func TestTrailers(t *testing.T) {
+	// Test: Trailers follow the last chunk
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	hs := DefaultHeaders()
+	hs.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(hs))
+	_, err := w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := DefaultHeaders()
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Error(t, w.WriteTrailers(trailers))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n0\r\nContent-Type: text/plain\r\n\r\n"))
+
+	// Test: Bodies that aren't chunked can't have trailers
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	require.NoError(t, w.Finish())
+	assert.ErrorIs(t, w.WriteTrailers(trailers), ErrTrailersNotAllowed)
+}
+
+// countingWriter counts the writes reaching it, each of which would be a
+// syscall on a connection.
+type countingWriter struct {
+	writes int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.writes++
+	return len(p), nil
+}
+
+// headerHeavyHeaders returns many header fields for a small response.
+func headerHeavyHeaders() *headers.Headers {
+	hs := GetDefaultHeaders(len("hello world"))
+	for i := range 20 {
+		hs.Add(fmt.Sprintf("X-Header-%d", i), "some value")
+	}
+	return hs
+}
+
+// writeHeaderHeavyResponse writes a small response with many header fields
+// through a Writer.
+func writeHeaderHeavyResponse(dst io.Writer) error {
+	w := NewWriter(dst)
+	if err := w.WriteStatusLine(StatusOK); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(headerHeavyHeaders()); err != nil {
+		return err
+	}
+	if _, err := w.WriteBody([]byte("hello world")); err != nil {
+		return err
+	}
+	return w.Finish()
+}
+
+// writeHeaderHeavyUnbuffered writes the same response line by line straight
+// to dst, the way Writer did before its writes were buffered.
+func writeHeaderHeavyUnbuffered(dst io.Writer) error {
+	if _, err := io.WriteString(dst, "HTTP/1.1 200 OK\r\n"); err != nil {
+		return err
+	}
+	for k, v := range headerHeavyHeaders().All() {
+		if _, err := io.WriteString(dst, fmt.Sprintf("%s: %s\r\n", k, v)); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(dst, "\r\n"); err != nil {
+		return err
+	}
+	_, err := io.WriteString(dst, "hello world")
+	return err
+}
+
+func BenchmarkHeaderHeavyResponse(b *testing.B) {
+	writers := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"unbuffered", writeHeaderHeavyUnbuffered},
+		{"buffered", writeHeaderHeavyResponse},
+	}
+
+	for _, wr := range writers {
+		b.Run(wr.name+"/writes", func(b *testing.B) {
+			var out countingWriter
+			for b.Loop() {
+				if err := wr.write(&out); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(out.writes)/float64(b.N), "writes/op")
+		})
+	}
+
+	for _, wr := range writers {
+		b.Run(wr.name+"/tcp", func(b *testing.B) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(b, err)
+			defer l.Close()
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				io.Copy(io.Discard, conn)
+			}()
+			conn, err := net.Dial("tcp", l.Addr().String())
+			require.NoError(b, err)
+			defer conn.Close()
+
+			for b.Loop() {
+				if err := wr.write(conn); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
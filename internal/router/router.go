@@ -0,0 +1,299 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/rousage/httpfromtcp/internal/headers"
+	"github.com/rousage/httpfromtcp/internal/request"
+	"github.com/rousage/httpfromtcp/internal/response"
+	"github.com/rousage/httpfromtcp/internal/server"
+)
+
+// Router dispatches requests to the handler registered for their method and
+// path. Its Serve method is a server.Handler.
+//
+// Patterns are made of "/"-separated segments, each of which is either
+// static, a named parameter such as "{id}" matching one non-empty segment,
+// or, in last position only, a wildcard such as "{path...}" matching the
+// rest of the path, possibly empty. When several patterns match a path, the
+// one with a static segment where the others have a parameter or a
+// wildcard wins, and a parameter wins over a wildcard. Matched values are
+// available through Request.PathValue.
+//
+// Requests for an unknown path get a 404 response, and requests for a known
+// path with another method a 405 one listing the allowed methods. OPTIONS
+// requests are answered automatically unless a handler is registered for
+// them, and HEAD requests fall back to the GET handler.
+type Router struct {
+	routes []*route
+}
+
+type route struct {
+	pattern  string
+	segments []segment
+	handlers map[string]server.Handler
+}
+
+type segmentKind int
+
+// The order of segment kinds is their matching priority.
+const (
+	segmentStatic segmentKind = iota
+	segmentParam
+	segmentWildcard
+)
+
+type segment struct {
+	kind segmentKind
+	// value is the text of a static segment or the name of a parameter
+	value string
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for requests with the given method and a path
+// matching pattern. It panics if the pattern is invalid or already has a
+// handler for that method.
+func (rt *Router) Handle(method, pattern string, handler server.Handler) {
+	if !headers.IsToken(method) {
+		panic(fmt.Sprintf("router: invalid method %q", method))
+	}
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("router: invalid pattern %q: %v", pattern, err))
+	}
+
+	r := rt.find(segments)
+	if r != nil && !slices.Equal(r.segments, segments) {
+		panic(fmt.Sprintf("router: %s conflicts with %s", pattern, r.pattern))
+	}
+	if r == nil {
+		r = &route{pattern: pattern, segments: segments, handlers: make(map[string]server.Handler)}
+		rt.routes = append(rt.routes, r)
+	}
+	if _, ok := r.handlers[method]; ok {
+		panic(fmt.Sprintf("router: %s %s is already registered", method, pattern))
+	}
+	r.handlers[method] = handler
+}
+
+// find returns the route matching the same paths as segments, which only
+// differs by its parameter names if any.
+func (rt *Router) find(segments []segment) *route {
+	for _, r := range rt.routes {
+		if slices.EqualFunc(r.segments, segments, func(a, b segment) bool {
+			return a.kind == b.kind && (a.kind != segmentStatic || a.value == b.value)
+		}) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Serve dispatches req to the matching handler, or answers it itself.
+func (rt *Router) Serve(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+
+	// "OPTIONS *" asks about the server as a whole
+	if method == "OPTIONS" && req.RequestLine.RequestTarget == "*" {
+		serveOptions(w, rt.allowedMethods(rt.routes...))
+		return
+	}
+
+	matches := rt.match(req.RequestLine.URL.EscapedPath())
+	if len(matches) == 0 {
+		w.WriteError(response.StatusNotFound, "")
+		return
+	}
+
+	// Less specific routes still get the requests for the methods the more
+	// specific ones don't handle
+	for _, m := range matches {
+		handler, ok := m.route.handler(method)
+		if !ok {
+			continue
+		}
+		for i, seg := range m.route.segments {
+			if seg.kind != segmentStatic {
+				req.SetPathValue(seg.value, m.values[i])
+			}
+		}
+		handler(w, req)
+		return
+	}
+
+	routes := make([]*route, len(matches))
+	for i, m := range matches {
+		routes[i] = m.route
+	}
+	allow := rt.allowedMethods(routes...)
+	if method == "OPTIONS" {
+		serveOptions(w, allow)
+		return
+	}
+	w.Header().Set("Allow", allow)
+	w.WriteError(response.StatusMethodNotAllowed, "")
+}
+
+// routeMatch is a route matching a path, along with the unescaped value of
+// each of its segments.
+type routeMatch struct {
+	route  *route
+	values []string
+}
+
+// match returns the routes matching path, the most specific first.
+func (rt *Router) match(path string) []routeMatch {
+	if !strings.HasPrefix(path, "/") {
+		return nil
+	}
+	parts := strings.Split(path[1:], "/")
+
+	var matches []routeMatch
+	for _, r := range rt.routes {
+		if values, ok := r.match(parts); ok {
+			matches = append(matches, routeMatch{route: r, values: values})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b routeMatch) int {
+		switch {
+		case a.route.moreSpecific(b.route):
+			return -1
+		case b.route.moreSpecific(a.route):
+			return 1
+		}
+		return 0
+	})
+
+	return matches
+}
+
+// handler returns the handler of r for method, HEAD falling back to GET.
+func (r *route) handler(method string) (server.Handler, bool) {
+	handler, ok := r.handlers[method]
+	if !ok && method == "HEAD" {
+		handler, ok = r.handlers["GET"]
+	}
+	return handler, ok
+}
+
+func (r *route) match(parts []string) ([]string, bool) {
+	values := make([]string, len(r.segments))
+	for i, seg := range r.segments {
+		if seg.kind == segmentWildcard {
+			value, err := url.PathUnescape(strings.Join(parts[i:], "/"))
+			if err != nil {
+				return nil, false
+			}
+			values[i] = value
+			return values, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+
+		value, err := url.PathUnescape(parts[i])
+		if err != nil {
+			return nil, false
+		}
+		switch seg.kind {
+		case segmentStatic:
+			if value != seg.value {
+				return nil, false
+			}
+		case segmentParam:
+			if value == "" {
+				return nil, false
+			}
+		}
+		values[i] = value
+	}
+
+	if len(parts) != len(r.segments) {
+		return nil, false
+	}
+	return values, true
+}
+
+// moreSpecific reports whether r takes priority over other, comparing the
+// kind of their segments from left to right.
+func (r *route) moreSpecific(other *route) bool {
+	for i := range min(len(r.segments), len(other.segments)) {
+		if r.segments[i].kind != other.segments[i].kind {
+			return r.segments[i].kind < other.segments[i].kind
+		}
+	}
+	return false
+}
+
+// allowedMethods returns the methods registered for the given routes as the
+// value of an Allow header, with HEAD and OPTIONS added as they are handled
+// by the router.
+func (rt *Router) allowedMethods(routes ...*route) string {
+	methods := []string{"OPTIONS"}
+	for _, r := range routes {
+		for method := range r.handlers {
+			methods = append(methods, method)
+			if method == "GET" {
+				methods = append(methods, "HEAD")
+			}
+		}
+	}
+	slices.Sort(methods)
+
+	return strings.Join(slices.Compact(methods), ", ")
+}
+
+// serveOptions answers an OPTIONS request with the allowed methods.
+// https://datatracker.ietf.org/doc/html/rfc9110#name-options
+func serveOptions(w *response.Writer, allow string) {
+	hs := headers.NewHeaders()
+	hs.Set("Allow", allow)
+	w.WriteStatusLine(response.StatusNoContent)
+	w.WriteHeaders(hs)
+}
+
+// parsePattern splits a pattern into its segments.
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, errors.New("must start with /")
+	}
+
+	parts := strings.Split(pattern[1:], "/")
+	segments := make([]segment, 0, len(parts))
+	names := make(map[string]bool)
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("segment %q mixes text and a parameter", part)
+			}
+			segments = append(segments, segment{kind: segmentStatic, value: part})
+			continue
+		}
+
+		name := part[1 : len(part)-1]
+		kind := segmentParam
+		if n, ok := strings.CutSuffix(name, "..."); ok {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("wildcard %q is not the last segment", part)
+			}
+			name, kind = n, segmentWildcard
+		}
+		if name == "" || strings.ContainsAny(name, "{}") {
+			return nil, fmt.Errorf("invalid parameter %q", part)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate parameter %q", name)
+		}
+		names[name] = true
+		segments = append(segments, segment{kind: kind, value: name})
+	}
+
+	return segments, nil
+}
@@ -0,0 +1,111 @@
+package router
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rousage/httpfromtcp/internal/request"
+	"github.com/rousage/httpfromtcp/internal/response"
+)
+
+// serve sends a request for method and target through rt and returns the
+// raw response.
+func serve(t *testing.T, rt *Router, method, target string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	rt.Serve(w, req)
+	w.Finish()
+	return buf.String()
+}
+
+// echo returns a handler writing name and the given path values.
+func echo(name string, params ...string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		body := name
+		for _, p := range params {
+			body += " " + p + "=" + req.PathValue(p)
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+}
+
+func TestRouterMatch(t *testing.T) {
+	rt := New()
+	rt.Handle("GET", "/", echo("root"))
+	rt.Handle("GET", "/users/{id}", echo("user", "id"))
+	rt.Handle("GET", "/users/me", echo("me"))
+	rt.Handle("GET", "/users/{id}/posts/{post}", echo("post", "id", "post"))
+	rt.Handle("GET", "/files/{path...}", echo("files", "path"))
+	rt.Handle("GET", "/{path...}", echo("fallback", "path"))
+
+	// Test: Static routes
+	assert.True(t, strings.HasSuffix(serve(t, rt, "GET", "/"), "\r\n\r\nroot"))
+	assert.True(t, strings.HasSuffix(serve(t, rt, "GET", "/users/me"), "\r\n\r\nme"))
+
+	// Test: Named parameters are unescaped
+	assert.True(t, strings.HasSuffix(serve(t, rt, "GET", "/users/42"), "\r\n\r\nuser id=42"))
+	assert.True(t, strings.HasSuffix(serve(t, rt, "GET", "/users/a%2Fb"), "\r\n\r\nuser id=a/b"))
+	assert.True(t, strings.HasSuffix(serve(t, rt, "GET", "/users/7/posts/9"), "\r\n\r\npost id=7 post=9"))
+
+	// Test: Wildcards match the rest of the path, possibly empty
+	assert.True(t, strings.HasSuffix(serve(t, rt, "GET", "/files/a/b.txt"), "\r\n\r\nfiles path=a/b.txt"))
+	assert.True(t, strings.HasSuffix(serve(t, rt, "GET", "/files/"), "\r\n\r\nfiles path="))
+	assert.True(t, strings.HasSuffix(serve(t, rt, "GET", "/users/"), "\r\n\r\nfallback path=users/"))
+	assert.True(t, strings.HasSuffix(serve(t, rt, "GET", "/other?q=1"), "\r\n\r\nfallback path=other"))
+
+	// Test: HEAD falls back to GET
+	assert.True(t, strings.HasPrefix(serve(t, rt, "HEAD", "/users/me"), "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestRouterErrors(t *testing.T) {
+	rt := New()
+	rt.Handle("GET", "/users/{id}", echo("user", "id"))
+	rt.Handle("DELETE", "/users/{id}", echo("delete", "id"))
+	rt.Handle("POST", "/users", echo("create"))
+
+	// Test: Unknown path
+	res := serve(t, rt, "GET", "/posts/1")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Known path with another method
+	res = serve(t, rt, "PUT", "/users/1")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, res, "\r\nAllow: DELETE, GET, HEAD, OPTIONS\r\n")
+
+	// Test: OPTIONS lists the methods of a path
+	res = serve(t, rt, "OPTIONS", "/users")
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\nAllow: OPTIONS, POST\r\n\r\n", res)
+
+	// Test: OPTIONS * lists the methods of all paths
+	res = serve(t, rt, "OPTIONS", "*")
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\nAllow: DELETE, GET, HEAD, OPTIONS, POST\r\n\r\n", res)
+
+	// Test: Less specific routes handle the methods of more specific ones
+	rt.Handle("POST", "/{path...}", echo("fallback", "path"))
+	res = serve(t, rt, "POST", "/users/1")
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\nfallback path=users/1"))
+	res = serve(t, rt, "GET", "/users/1")
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\nuser id=1"))
+
+	// Test: Allow lists the methods of all the matching routes
+	res = serve(t, rt, "PUT", "/users/1")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, res, "\r\nAllow: DELETE, GET, HEAD, OPTIONS, POST\r\n")
+
+	// Test: Invalid patterns
+	assert.Panics(t, func() { rt.Handle("GET", "users", echo("")) })
+	assert.Panics(t, func() { rt.Handle("GET", "/{path...}/x", echo("")) })
+	assert.Panics(t, func() { rt.Handle("GET", "/{id}/{id}", echo("")) })
+	assert.Panics(t, func() { rt.Handle("GET", "/users/{name}", echo("")) })
+	assert.Panics(t, func() { rt.Handle("GET", "/users/{id}", echo("")) })
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rousage/httpfromtcp/internal/request"
+	"github.com/rousage/httpfromtcp/internal/response"
+)
+
+// AccessLogFormat selects how WithAccessLog writes its entries.
+type AccessLogFormat int
+
+const (
+	// LogCommon is the Common Log Format:
+	// host ident authuser [date] "request line" status bytes
+	LogCommon AccessLogFormat = iota
+	// LogCombined is the Common Log Format followed by the quoted Referer
+	// and User-Agent of the request.
+	LogCombined
+	// LogJSON writes one JSON object per line with log/slog, including the
+	// duration of the request, which the other formats have no field for.
+	LogJSON
+)
+
+// clfTimeFormat is the date format of the Common Log Format.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// WithAccessLog writes an entry to out for each request once its response
+// is done, in the given format. Requests the server could not read are not
+// logged.
+func WithAccessLog(out io.Writer, format AccessLogFormat) Option {
+	return func(s *Server) {
+		s.accessLog = newAccessLogger(out, format)
+	}
+}
+
+// accessEntry is what is known about a request once it has been served.
+type accessEntry struct {
+	req      *request.Request
+	status   response.StatusCode
+	bytes    int
+	start    time.Time
+	duration time.Duration
+}
+
+type accessLogger struct {
+	format AccessLogFormat
+	slog   *slog.Logger
+
+	// mu keeps the lines of concurrent requests from interleaving
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func newAccessLogger(out io.Writer, format AccessLogFormat) *accessLogger {
+	l := &accessLogger{format: format, out: out}
+	if format == LogJSON {
+		l.slog = slog.New(slog.NewJSONHandler(out, nil))
+	}
+
+	return l
+}
+
+func (l *accessLogger) log(e accessEntry) {
+	rl := e.req.RequestLine
+	if l.format == LogJSON {
+		l.slog.LogAttrs(context.Background(), slog.LevelInfo, "request",
+			slog.String("remote_addr", e.req.RemoteAddr),
+			slog.String("method", rl.Method),
+			slog.String("target", rl.RequestTarget),
+			slog.String("version", "HTTP/"+rl.HttpVersion),
+			slog.Int("status", int(e.status)),
+			slog.Int("bytes", e.bytes),
+			slog.Duration("duration", e.duration),
+			slog.String("user_agent", e.req.Headers.Get("User-Agent")),
+			slog.String("referer", e.req.Headers.Get("Referer")),
+		)
+		return
+	}
+
+	host, _, err := net.SplitHostPort(e.req.RemoteAddr)
+	if err != nil || host == "" {
+		host = "-"
+	}
+	bytes := "-"
+	if e.bytes > 0 {
+		bytes = fmt.Sprint(e.bytes)
+	}
+	line := fmt.Sprintf("%s - - [%s] \"%s\" %d %s",
+		host,
+		e.start.Format(clfTimeFormat),
+		escapeLogField(fmt.Sprintf("%s %s HTTP/%s", rl.Method, rl.RequestTarget, rl.HttpVersion)),
+		e.status,
+		bytes,
+	)
+	if l.format == LogCombined {
+		line += fmt.Sprintf(" \"%s\" \"%s\"",
+			escapeLogField(e.req.Headers.Get("Referer")),
+			escapeLogField(e.req.Headers.Get("User-Agent")),
+		)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.out, line+"\n")
+}
+
+// escapeLogField escapes s to be written between double quotes, the way
+// Apache does, so that client data cannot forge log entries. Empty fields
+// are written as "-".
+func escapeLogField(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
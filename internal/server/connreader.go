@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// connReader reads requests from a connection. While a handler runs on a
+// request whose body has been read, it keeps reading the connection in the
+// background to notice the client going away, and keeps the byte it may
+// read instead, the start of a pipelined request, for the next Read.
+type connReader struct {
+	conn net.Conn
+
+	mu sync.Mutex
+	// done is closed once the background read returns, nil when there is
+	// none
+	done chan struct{}
+	// pending holds the byte read in the background if hasPending is set
+	pending    [1]byte
+	hasPending bool
+	// err is the error returned by the background read, which Read returns
+	// once the pending byte has been consumed
+	err error
+}
+
+func (cr *connReader) Read(p []byte) (int, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if cr.done != nil {
+		panic("concurrent read of the connection")
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if cr.hasPending {
+		p[0] = cr.pending[0]
+		cr.hasPending = false
+		return 1, nil
+	}
+	if cr.err != nil {
+		return 0, cr.err
+	}
+
+	return cr.conn.Read(p)
+}
+
+// startBackgroundRead reads a byte from the connection in the background,
+// calling cancel if the connection fails or the client closed it.
+func (cr *connReader) startBackgroundRead(cancel context.CancelFunc) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	cr.done = make(chan struct{})
+	go func() {
+		n, err := cr.conn.Read(cr.pending[:])
+
+		cr.mu.Lock()
+		cr.hasPending = n > 0
+		// The deadline set by abortPendingRead is not the client's doing
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			cr.err = err
+			cancel()
+		}
+		close(cr.done)
+		cr.done = nil
+		cr.mu.Unlock()
+	}()
+}
+
+// abortPendingRead stops the background read, if any, and waits for it to
+// return. The caller must set a new read deadline before the next Read.
+func (cr *connReader) abortPendingRead() {
+	cr.mu.Lock()
+	done := cr.done
+	cr.mu.Unlock()
+	if done == nil {
+		return
+	}
+
+	// A deadline in the past unblocks the read
+	cr.conn.SetReadDeadline(time.Unix(1, 0))
+	<-done
+}
+
+// clientGone reports whether the background read found the connection
+// closed or broken.
+func (cr *connReader) clientGone() bool {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	return cr.err != nil
+}
@@ -0,0 +1,16 @@
+package server
+
+// Middleware wraps a Handler with behaviour shared by all the requests, such
+// as logging or recovering from panics.
+type Middleware func(Handler) Handler
+
+// Chain composes middlewares into a single one. The first middleware is the
+// outermost, so Chain(a, b)(h) is a(b(h)) and a sees the request first.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(h Handler) Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			h = middlewares[i](h)
+		}
+		return h
+	}
+}
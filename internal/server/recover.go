@@ -0,0 +1,33 @@
+package server
+
+import (
+	"log"
+	"runtime/debug"
+
+	"github.com/rousage/httpfromtcp/internal/request"
+	"github.com/rousage/httpfromtcp/internal/response"
+)
+
+// RecoverPanic answers a request whose handler panicked with v, and must be
+// called from the deferred function that recovered it. The panic is logged
+// with its stack to logger, or log.Default() if nil. The client gets a 500
+// response if nothing of the handler's response was sent yet, and the
+// connection is closed either way since the request body and the response
+// may have been left half done. The stack is returned, e.g. to report it
+// elsewhere.
+func RecoverPanic(logger *log.Logger, w *response.Writer, req *request.Request, v any) []byte {
+	if logger == nil {
+		logger = log.Default()
+	}
+	stack := debug.Stack()
+	logger.Printf("panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, v, stack)
+
+	if w.Discard() {
+		w.CloseConnection()
+		w.WriteError(response.StatusInternalServerError, "")
+	} else {
+		w.Abort()
+	}
+
+	return stack
+}
@@ -1,11 +1,18 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"os"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/rousage/httpfromtcp/internal/request"
 	"github.com/rousage/httpfromtcp/internal/response"
@@ -18,35 +25,299 @@ type HandlerError struct {
 	Message    string
 }
 
-func (he *HandlerError) Write(w io.Writer) {
-	io.WriteString(w, fmt.Sprintf("HTTP/1.1 %d %s\r\n", he.StatusCode, he.Message))
+// Write writes he as a complete response, rendered by the server's
+// ErrorRenderer if one was configured with WithErrorRenderer.
+func (he *HandlerError) Write(w *response.Writer) error {
+	return w.WriteError(he.StatusCode, he.Message)
 }
 
+// Connection states tracked to know which connections can be closed
+// during a graceful shutdown.
+const (
+	// connIdle is a connection waiting for its next request
+	connIdle = iota
+	// connActive is a connection in the middle of a request
+	connActive
+)
+
+// shutdownPollInterval is how often Shutdown checks whether all the
+// connections have finished.
+const shutdownPollInterval = 50 * time.Millisecond
+
+// maxDiscardBytes is how much of a request body left unread by the handler
+// is discarded to keep the connection alive. Connections with more than
+// that left are closed instead.
+const maxDiscardBytes = 256 << 10
+
+// lingerTimeout is how long the rest of a bad request is drained before the
+// connection is closed.
+const lingerTimeout = 500 * time.Millisecond
+
 type Server struct {
 	listener net.Listener
 	handler  Handler
 	closed   atomic.Bool
+	// baseCtx is the parent of the request contexts, cancelled when the
+	// server is closed
+	baseCtx    context.Context
+	cancelBase context.CancelFunc
+
+	mu    sync.Mutex
+	conns map[net.Conn]int
+
+	readHeaderTimeout time.Duration
+	readBodyTimeout   time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+
+	limits        request.Limits
+	lenient       bool
+	errorRenderer response.ErrorRenderer
+	panicHandler  PanicHandler
+	accessLog     *accessLogger
+
+	// tlsConfig is the TLS configuration of the listener once the server
+	// started, nil if it serves plain connections
+	tlsConfig *tls.Config
+	// certFiles are pairs of certificate and key files
+	certFiles []string
+}
+
+// PanicHandler is called when a handler panics, with the value passed to
+// panic and the stack of the goroutine, e.g. to report it to an error
+// tracker. It runs after the panic has been logged and the response
+// finished.
+type PanicHandler func(req *request.Request, v any, stack []byte)
+
+// Option configures optional Server behaviour.
+type Option func(*Server)
+
+// WithReadHeaderTimeout limits the time to read the request line and headers.
+func WithReadHeaderTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.readHeaderTimeout = d
+	}
+}
+
+// WithReadBodyTimeout limits the time to read the request body once the
+// headers have been read.
+func WithReadBodyTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.readBodyTimeout = d
+	}
+}
+
+// WithWriteTimeout limits the time the handler has to write the response.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.writeTimeout = d
+	}
+}
+
+// WithIdleTimeout limits how long a keep-alive connection waits for the
+// next request. If zero, the read header timeout is used instead.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.idleTimeout = d
+	}
+}
+
+// WithLimits bounds the size of the requests the server accepts. Requests
+// over the limits are answered with 413, 414 or 431. Defaults to
+// request.DefaultLimits.
+func WithLimits(limits request.Limits) Option {
+	return func(s *Server) {
+		s.limits = limits
+	}
+}
+
+// WithLenientParsing relaxes the request parsing rules that guard against
+// request smuggling, see request.Parser.Lenient. Only enable it when every
+// client is trusted, never behind a proxy or load balancer.
+func WithLenientParsing(lenient bool) Option {
+	return func(s *Server) {
+		s.lenient = lenient
+	}
+}
+
+// WithErrorRenderer sets how error responses are rendered, both the ones
+// sent by the server for requests it cannot read and the HandlerErrors
+// written by handlers. Defaults to response.RenderTextError.
+func WithErrorRenderer(renderer response.ErrorRenderer) Option {
+	return func(s *Server) {
+		s.errorRenderer = renderer
+	}
+}
+
+// WithPanicHandler sets a function called whenever a handler panics.
+func WithPanicHandler(h PanicHandler) Option {
+	return func(s *Server) {
+		s.panicHandler = h
+	}
+}
+
+// Serve listens on the given TCP port on all interfaces and serves handler
+// on the accepted connections.
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
+	return ServeAddr(fmt.Sprintf(":%d", port), handler, opts...)
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
-	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// ServeAddr is like Serve, but listens on addr. It is either a TCP address
+// such as "localhost:8080", or ":0" for a port picked by the system (see
+// Addr), or "unix:" followed by the path of a Unix domain socket.
+func ServeAddr(addr string, handler Handler, opts ...Option) (*Server, error) {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", path
+	}
+
+	// The options are checked first so that no listener is left open
+	s, err := newServer(handler, opts)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	s.start(l)
+
+	return s, nil
+}
+
+// ServeListener is like Serve, but serves the connections accepted from l,
+// e.g. one returned by SystemdListeners. l is closed with the server.
+func ServeListener(l net.Listener, handler Handler, opts ...Option) (*Server, error) {
+	s, err := newServer(handler, opts)
 	if err != nil {
 		return nil, err
 	}
+	s.start(l)
 
+	return s, nil
+}
+
+func newServer(handler Handler, opts []Option) (*Server, error) {
 	s := &Server{
-		listener: l,
-		handler:  handler,
+		handler: handler,
+		conns:   make(map[net.Conn]int),
+		limits:  request.DefaultLimits,
+	}
+	s.baseCtx, s.cancelBase = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(s)
 	}
 
-	go s.listen()
+	tlsConfig, err := s.serverTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	s.tlsConfig = tlsConfig
 
 	return s, nil
 }
 
+// start accepts connections from l in the background.
+func (s *Server) start(l net.Listener) {
+	if s.tlsConfig != nil {
+		l = tls.NewListener(l, s.tlsConfig)
+	}
+	s.listener = l
+
+	go s.listen()
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections and immediately closes all the active
+// ones, cancelling the contexts of their requests. Use Shutdown to let
+// in-flight requests finish.
 func (s *Server) Close() error {
 	s.closed.Store(true)
-	return s.listener.Close()
+	err := s.listener.Close()
+	s.cancelBase()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+
+	return err
+}
+
+// Shutdown gracefully stops the server. It stops accepting new connections,
+// closes idle keep-alive connections and waits for active ones to finish
+// their current request. If ctx expires first, the remaining connections
+// are closed, the contexts of their requests cancelled, and the context's
+// error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closed.Store(true)
+	err := s.listener.Close()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			s.cancelBase()
+			s.mu.Lock()
+			for conn := range s.conns {
+				conn.Close()
+			}
+			s.mu.Unlock()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdleConns wakes up all the idle connections waiting for a request,
+// which then close themselves, and reports whether no connections remain.
+// An idle connection may have received the start of a request already, it
+// is not closed under its feet but left to become active and serve it.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, state := range s.conns {
+		if state == connIdle {
+			// A deadline in the past unblocks the read
+			conn.SetReadDeadline(time.Unix(1, 0))
+		}
+	}
+
+	return len(s.conns) == 0
+}
+
+// setConnState records the state of a connection. It reports false if the
+// server is shutting down and the connection should not wait for a new
+// request. Connections only leave the map in removeConn, so an idle
+// connection that received a request can always become active.
+func (s *Server) setConnState(conn net.Conn, state int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed.Load() && state == connIdle {
+		return false
+	}
+	s.conns[conn] = state
+
+	return true
+}
+
+func (s *Server) removeConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
 }
 
 func (s *Server) listen() {
@@ -66,15 +337,204 @@ func (s *Server) listen() {
 
 func (s *Server) handle(conn net.Conn) {
 	defer conn.Close()
+	defer s.removeConn(conn)
 
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		hErr := &HandlerError{StatusCode: response.StatusBadRequest, Message: err.Error()}
-		hErr.Write(conn)
-		return
+	cr := &connReader{conn: conn}
+	parser := request.NewParser(cr)
+	parser.Limits = s.limits
+	parser.Lenient = s.lenient
+	for firstRequest := true; ; firstRequest = false {
+		if !s.setConnState(conn, connIdle) {
+			return
+		}
+
+		idleTimeout := s.readHeaderTimeout
+		if !firstRequest && s.idleTimeout > 0 {
+			idleTimeout = s.idleTimeout
+		}
+		conn.SetReadDeadline(deadline(idleTimeout))
+		// The client closed an idle connection or never started a new
+		// request, nothing to answer
+		if err := parser.WaitForRequest(); err != nil {
+			return
+		}
+		// From now on Shutdown leaves the read deadline alone and waits for
+		// the response
+		s.setConnState(conn, connActive)
+		conn.SetReadDeadline(deadline(s.readHeaderTimeout))
+		req, err := parser.ReadRequest()
+		if err != nil {
+			s.writeReadError(conn, err)
+			return
+		}
+
+		// The body is read by the handler, the deadline also covers
+		// discarding what it left unread
+		conn.SetReadDeadline(deadline(s.readBodyTimeout))
+		conn.SetWriteDeadline(deadline(s.writeTimeout))
+
+		if addr := conn.RemoteAddr(); addr != nil {
+			req.RemoteAddr = addr.String()
+		}
+		setTLSState(req, conn)
+		w := s.newWriter(conn)
+		w.SetRequestVersion(req.RequestLine.HttpVersion)
+		w.SetRequestMethod(req.RequestLine.Method)
+		if !keepAlive(req) {
+			w.CloseConnection()
+		}
+
+		ctx, cancel := s.requestContext()
+		// Once the body has been read, the connection can be watched for
+		// the client going away while the handler runs
+		parser.OnBodyComplete(req, func() {
+			conn.SetReadDeadline(time.Time{})
+			cr.startBackgroundRead(cancel)
+		})
+		start := time.Now()
+		s.serveRequest(w, req.WithContext(ctx))
+		cr.abortPendingRead()
+		cancel()
+		if err := w.Finish(); err != nil {
+			w.CloseConnection()
+		}
+		if s.accessLog != nil {
+			s.accessLog.log(accessEntry{
+				req:      req,
+				status:   w.StatusCode(),
+				bytes:    w.BodyBytes(),
+				start:    start,
+				duration: time.Since(start),
+			})
+		}
+
+		if w.ShouldClose() || cr.clientGone() {
+			return
+		}
+		// The next request starts right after this body
+		if err := parser.DiscardBody(req, maxDiscardBytes); err != nil {
+			return
+		}
+		conn.SetWriteDeadline(time.Time{})
 	}
+}
+
+// requestContext returns the context of a new request. It is cancelled when
+// the server is closed and once the response is due.
+func (s *Server) requestContext() (context.Context, context.CancelFunc) {
+	if s.writeTimeout > 0 {
+		return context.WithTimeout(s.baseCtx, s.writeTimeout)
+	}
+	return context.WithCancel(s.baseCtx)
+}
+
+// serveRequest runs the handler, recovering from its panics with
+// RecoverPanic so that a single request cannot crash the whole server.
+func (s *Server) serveRequest(w *response.Writer, req *request.Request) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		stack := RecoverPanic(nil, w, req, v)
+		if s.panicHandler != nil {
+			s.panicHandler(req, v, stack)
+		}
+	}()
 
-	w := response.NewWriter(conn)
 	s.handler(w, req)
+}
+
+func (s *Server) newWriter(conn net.Conn) *response.Writer {
+	w := response.NewWriter(conn)
+	w.SetClosing(s.closed.Load)
+	if s.errorRenderer != nil {
+		w.SetErrorRenderer(s.errorRenderer)
+	}
+
+	return w
+}
+
+// writeReadError answers a request that could not be read. Nothing is sent
+// if the client went away, since there is no one left to read it.
+func (s *Server) writeReadError(conn net.Conn, err error) {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return
+	}
+
+	hErr := &HandlerError{StatusCode: statusForReadError(err), Message: err.Error()}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		// Don't leak the details of the network error
+		hErr.Message = ""
+		// The client stopped sending, don't let the answer block as well
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+	}
 
+	// The rest of the request can't be told apart from the next one
+	w := s.newWriter(conn)
+	w.CloseConnection()
+	hErr.Write(w)
+	w.Finish()
+
+	lingerClose(conn)
+}
+
+// lingerClose half-closes conn and drains what the client is still sending
+// for a little while. Closing a socket with unread data makes the kernel
+// reset the connection, and the client may lose the error response.
+func lingerClose(conn net.Conn) {
+	cw, ok := conn.(interface{ CloseWrite() error })
+	if !ok {
+		return
+	}
+	if err := cw.CloseWrite(); err != nil {
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+	io.CopyN(io.Discard, conn, maxDiscardBytes)
+}
+
+// statusForReadError maps an error returned while reading a request to the
+// status code it should be answered with.
+func statusForReadError(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return response.StatusRequestTimeout
+	case errors.Is(err, request.ErrRequestLineTooLong):
+		return response.StatusURITooLong
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		return response.StatusRequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.StatusContentTooLarge
+	case errors.Is(err, request.ErrUnsupportedMethod), errors.Is(err, request.ErrUnsupportedTransferCoding):
+		return response.StatusNotImplemented
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		return response.StatusHTTPVersionNotSupported
+	default:
+		return response.StatusBadRequest
+	}
+}
+
+// deadline returns the deadline for an operation limited by d, or the zero
+// time if d is not set.
+func deadline(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+// keepAlive reports whether the client wants the connection to stay open
+// after the response. HTTP/1.1 connections are persistent by default while
+// HTTP/1.0 ones must ask for it.
+// https://datatracker.ietf.org/doc/html/rfc9112#name-persistence
+func keepAlive(req *request.Request) bool {
+	if req.Close {
+		return false
+	}
+	if req.RequestLine.IsHTTP10() {
+		return req.Headers.HasToken("Connection", "keep-alive")
+	}
+	return !req.Headers.HasToken("Connection", "close")
 }
@@ -0,0 +1,511 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rousage/httpfromtcp/internal/request"
+	"github.com/rousage/httpfromtcp/internal/response"
+)
+
+func okHandler(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(2))
+	w.WriteBody([]byte("ok"))
+}
+
+// roundTrip sends raw on a new connection to addr and returns everything
+// the server sent back until it closed the connection.
+func roundTrip(t *testing.T, addr net.Addr, raw string) string {
+	t.Helper()
+	conn, err := net.Dial(addr.Network(), addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+	res, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(res)
+}
+
+func TestServeAddr(t *testing.T) {
+	// Test: Ephemeral TCP port
+	s, err := ServeAddr("127.0.0.1:0", okHandler)
+	require.NoError(t, err)
+	defer s.Close()
+	res := roundTrip(t, s.Addr(), "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\nok", res)
+
+	// Test: Unix domain socket
+	path := filepath.Join(t.TempDir(), "server.sock")
+	s, err = ServeAddr("unix:"+path, okHandler)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, path, s.Addr().String())
+	res = roundTrip(t, s.Addr(), "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\nok", res)
+}
+
+func TestServeListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s, err := ServeListener(l, okHandler)
+	require.NoError(t, err)
+	defer s.Close()
+
+	res := roundTrip(t, s.Addr(), "GET / HTTP/1.0\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\nok", res)
+}
+
+func TestRequestContext(t *testing.T) {
+	cancelled := make(chan error, 1)
+	s, err := ServeAddr("127.0.0.1:0", func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.URL.Path == "/wait" {
+			io.ReadAll(req.Body)
+			<-req.Context().Done()
+			cancelled <- req.Context().Err()
+			return
+		}
+		// Give the client time to pipeline the next request
+		time.Sleep(50 * time.Millisecond)
+		okHandler(w, req)
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: The context is cancelled when the client goes away
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	_, err = io.WriteString(conn, "GET /wait HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	conn.Close()
+	select {
+	case err := <-cancelled:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled")
+	}
+
+	// Test: Once the body has been read, the context is cancelled when the
+	// client goes away
+	conn, err = net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	_, err = io.WriteString(conn, "POST /wait HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello")
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+	select {
+	case err := <-cancelled:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled")
+	}
+
+	// Test: Requests sent while the handler runs are still served
+	conn, err = net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	res, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(res), "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestTimeouts(t *testing.T) {
+	// Test: A stalled request is answered with 408
+	s, err := ServeAddr("127.0.0.1:0", okHandler, WithReadHeaderTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	defer s.Close()
+	res := roundTrip(t, s.Addr(), "GET / HTTP/1.1\r\nHost: loc")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 408 Request Timeout\r\n"), res)
+	assert.Contains(t, res, "\r\nConnection: close\r\n")
+
+	// Test: An idle keep-alive connection is closed without a response
+	s, err = ServeAddr("127.0.0.1:0", okHandler,
+		WithReadHeaderTimeout(time.Minute),
+		WithIdleTimeout(50*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+	start := time.Now()
+	res = roundTrip(t, s.Addr(), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\n\r\nok", res)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Test: Without an idle timeout, the read header timeout is used
+	s, err = ServeAddr("127.0.0.1:0", okHandler, WithReadHeaderTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	defer s.Close()
+	start = time.Now()
+	res = roundTrip(t, s.Addr(), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\n\r\nok", res)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestStatusForReadError(t *testing.T) {
+	assert.Equal(t, response.StatusRequestTimeout, statusForReadError(os.ErrDeadlineExceeded))
+	assert.Equal(t, response.StatusURITooLong, statusForReadError(request.ErrRequestLineTooLong))
+	assert.Equal(t, response.StatusRequestHeaderFieldsTooLarge, statusForReadError(request.ErrHeaderTooLarge))
+	assert.Equal(t, response.StatusContentTooLarge, statusForReadError(request.ErrBodyTooLarge))
+	assert.Equal(t, response.StatusNotImplemented, statusForReadError(request.ErrUnsupportedTransferCoding))
+	assert.Equal(t, response.StatusBadRequest, statusForReadError(request.ErrMalformedRequestLine))
+}
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s, err := ServeAddr("127.0.0.1:0", func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		okHandler(w, req)
+	})
+	require.NoError(t, err)
+
+	// An idle keep-alive connection, done with its first request
+	idle, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer idle.Close()
+	_, err = io.WriteString(idle, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	res := make([]byte, 128)
+	n, err := idle.Read(res)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\n\r\nok", string(res[:n]))
+
+	// A keep-alive connection in the middle of a request
+	active, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer active.Close()
+	_, err = io.WriteString(active, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+
+	// Test: Idle connections are closed without a response
+	idle.SetReadDeadline(time.Now().Add(time.Second))
+	rest, err := io.ReadAll(idle)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+
+	// Test: Active requests finish, and their connection is closed
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown returned with a request in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	active.SetReadDeadline(time.Now().Add(time.Second))
+	rest, err = io.ReadAll(active)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\nok", string(rest))
+	select {
+	case err := <-shutdown:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown did not return")
+	}
+
+	// Test: New connections are refused
+	_, err = net.Dial("tcp", s.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	s, err := ServeAddr("127.0.0.1:0", func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+	})
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	// Test: Requests still running when the context expires are cut short
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadAll(conn)
+	assert.NoError(t, err)
+}
+
+func TestErrorRenderer(t *testing.T) {
+	renderer := func(w *response.Writer, statusCode response.StatusCode, message string) error {
+		body := fmt.Sprintf("<h1>%d</h1>", statusCode)
+		hs := response.GetDefaultHeaders(len(body))
+		hs.Set("Content-Type", "text/html")
+		w.WriteStatusLine(statusCode)
+		w.WriteHeaders(hs)
+		_, err := w.WriteBody([]byte(body))
+		return err
+	}
+	handler := func(w *response.Writer, req *request.Request) {
+		(&HandlerError{StatusCode: response.StatusForbidden, Message: "no"}).Write(w)
+	}
+
+	// Test: Requests the server cannot read use the default renderer
+	s, err := ServeAddr("127.0.0.1:0", handler)
+	require.NoError(t, err)
+	defer s.Close()
+	res := roundTrip(t, s.Addr(), "GET / HTTP/1.1\r\nBad Header\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 400 Bad Request\r\nContent-Length: "), res)
+	assert.Contains(t, res, "\r\nConnection: close\r\n\r\n400 ")
+
+	// Test: The custom renderer is used for read errors and HandlerErrors
+	s, err = ServeAddr("127.0.0.1:0", handler, WithErrorRenderer(renderer))
+	require.NoError(t, err)
+	defer s.Close()
+	res = roundTrip(t, s.Addr(), "GET / HTTP/1.1\r\nBad Header\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\nContent-Length: 12\r\nContent-Type: text/html\r\nConnection: close\r\n\r\n<h1>400</h1>", res)
+	res = roundTrip(t, s.Addr(), "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\nContent-Length: 12\r\nContent-Type: text/html\r\nConnection: close\r\n\r\n<h1>403</h1>", res)
+}
+
+func TestPanicRecovery(t *testing.T) {
+	type panicked struct {
+		v     any
+		stack []byte
+	}
+	panics := make(chan panicked, 1)
+	s, err := ServeAddr("127.0.0.1:0", func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.URL.Path {
+		case "/buffered":
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.DefaultHeaders())
+			w.Write([]byte("hello"))
+		case "/late":
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.GetDefaultHeaders(10))
+			w.Write([]byte("hello"))
+			w.Flush()
+		}
+		panic("boom")
+	}, WithPanicHandler(func(req *request.Request, v any, stack []byte) {
+		panics <- panicked{v, stack}
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: A panic before the status line is answered with 500 and the
+	// keep-alive connection closed
+	res := roundTrip(t, s.Addr(), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error\r\nContent-Length: 26\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\n500 Internal Server Error\n", res)
+	p := <-panics
+	assert.Equal(t, "boom", p.v)
+	assert.Contains(t, string(p.stack), "TestPanicRecovery")
+
+	// Test: A response not sent yet is replaced by the 500
+	res = roundTrip(t, s.Addr(), "GET /buffered HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error\r\nContent-Length: 26\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\n500 Internal Server Error\n", res)
+	<-panics
+
+	// Test: A panic halfway through the response cuts it short
+	res = roundTrip(t, s.Addr(), "GET /late HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\nContent-Type: text/plain\r\n\r\nhello", res)
+	p = <-panics
+	assert.Equal(t, "boom", p.v)
+}
+
+func TestAccessLog(t *testing.T) {
+	date := regexp.MustCompile(`\[[^]]+\]`)
+	serve := func(format AccessLogFormat, raw string) string {
+		var buf syncBuffer
+		s, err := ServeAddr("127.0.0.1:0", okHandler, WithAccessLog(&buf, format))
+		require.NoError(t, err)
+		roundTrip(t, s.Addr(), raw)
+		// The entry is written once the response is done
+		require.NoError(t, s.Shutdown(context.Background()))
+		return buf.String()
+	}
+	raw := "GET /coffee?size=large HTTP/1.1\r\nHost: localhost\r\nUser-Agent: curl \"8.0\"\r\nReferer: /menu\r\nConnection: close\r\n\r\n"
+
+	// Test: Common Log Format
+	line := serve(LogCommon, raw)
+	assert.Equal(t, "127.0.0.1 - - [date] \"GET /coffee?size=large HTTP/1.1\" 200 2\n", date.ReplaceAllString(line, "[date]"))
+
+	// Test: Combined Log Format
+	line = serve(LogCombined, raw)
+	assert.Equal(t, "127.0.0.1 - - [date] \"GET /coffee?size=large HTTP/1.1\" 200 2 \"/menu\" \"curl \\\"8.0\\\"\"\n", date.ReplaceAllString(line, "[date]"))
+
+	// Test: JSON
+	line = serve(LogJSON, raw)
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal([]byte(line), &entry))
+	assert.Equal(t, "request", entry["msg"])
+	assert.Equal(t, "GET", entry["method"])
+	assert.Equal(t, "/coffee?size=large", entry["target"])
+	assert.Equal(t, "HTTP/1.1", entry["version"])
+	assert.Equal(t, float64(200), entry["status"])
+	assert.Equal(t, float64(2), entry["bytes"])
+	assert.Equal(t, `curl "8.0"`, entry["user_agent"])
+	assert.Equal(t, "/menu", entry["referer"])
+	assert.Contains(t, entry, "duration")
+
+	// Test: Client data cannot forge entries
+	var buf bytes.Buffer
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Headers.Set("User-Agent", "x\" 200 2\n192.0.2.2 - - \\")
+	start := time.Date(2026, time.January, 2, 3, 4, 5, 0, time.UTC)
+	newAccessLogger(&buf, LogCombined).log(accessEntry{req: req, status: response.StatusNotFound, start: start})
+	assert.Equal(t, "192.0.2.1 - - [02/Jan/2026:03:04:05 +0000] \"GET / HTTP/1.1\" 404 - \"-\" \"x\\\" 200 2\\x0a192.0.2.2 - - \\\\\"\n", buf.String())
+}
+
+// syncBuffer is a bytes.Buffer safe to write from the server's goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// writeCert writes a self-signed certificate for name and its key into dir,
+// and returns the paths of both files.
+func writeCert(t *testing.T, dir, name string, serial int64) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+// tlsRoundTrip sends a request to addr over TLS asking for serverName, and
+// returns the certificate the server presented and the response body.
+func tlsRoundTrip(t *testing.T, addr net.Addr, serverName string) (*x509.Certificate, string) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr.String(), &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: "+serverName+"\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	res, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	_, body, _ := strings.Cut(string(res), "\r\n\r\n")
+	return conn.ConnectionState().PeerCertificates[0], body
+}
+
+func TestTLS(t *testing.T) {
+	interval := certCheckInterval
+	certCheckInterval = 0
+	defer func() { certCheckInterval = interval }()
+
+	dir := t.TempDir()
+	certA, keyA := writeCert(t, dir, "a.test", 1)
+	certB, keyB := writeCert(t, dir, "b.test", 2)
+	handler := func(w *response.Writer, req *request.Request) {
+		body := "plain"
+		if req.TLS != nil {
+			body = req.TLS.ServerName
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+	s, err := ServeAddr("127.0.0.1:0", handler, WithTLSCertificate(certA, keyA), WithTLSCertificate(certB, keyB))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: The certificate is picked by server name, the request gets the
+	// TLS state
+	cert, body := tlsRoundTrip(t, s.Addr(), "b.test")
+	assert.Equal(t, "b.test", cert.Subject.CommonName)
+	assert.Equal(t, "b.test", body)
+	cert, body = tlsRoundTrip(t, s.Addr(), "a.test")
+	assert.Equal(t, "a.test", cert.Subject.CommonName)
+	assert.Equal(t, "a.test", body)
+
+	// Test: The first certificate is the default
+	cert, _ = tlsRoundTrip(t, s.Addr(), "other.test")
+	assert.Equal(t, "a.test", cert.Subject.CommonName)
+
+	// Test: Renewed certificates are reloaded
+	writeCert(t, dir, "a.test", 3)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certA, later, later))
+	require.NoError(t, os.Chtimes(keyA, later, later))
+	cert, _ = tlsRoundTrip(t, s.Addr(), "a.test")
+	assert.Equal(t, int64(3), cert.SerialNumber.Int64())
+
+	// Test: A GetCertificate callback is asked before the files
+	certC, keyC := writeCert(t, dir, "c.test", 4)
+	pairC, err := tls.LoadX509KeyPair(certC, keyC)
+	require.NoError(t, err)
+	config := &tls.Config{GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if hello.ServerName == "c.test" {
+			return &pairC, nil
+		}
+		return nil, nil
+	}}
+	s, err = ServeAddr("127.0.0.1:0", handler, WithTLSConfig(config), WithTLSCertificate(certB, keyB))
+	require.NoError(t, err)
+	defer s.Close()
+	cert, _ = tlsRoundTrip(t, s.Addr(), "c.test")
+	assert.Equal(t, "c.test", cert.Subject.CommonName)
+	cert, _ = tlsRoundTrip(t, s.Addr(), "b.test")
+	assert.Equal(t, "b.test", cert.Subject.CommonName)
+}
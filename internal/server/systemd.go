@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// systemdFirstFD is the first file descriptor passed by systemd, after
+// stdin, stdout and stderr.
+const systemdFirstFD = 3
+
+// SystemdListeners returns the listeners passed by systemd socket
+// activation, in the order of the socket unit, to be used with
+// ServeListener. It returns none if the process was not socket activated.
+// The environment variables describing them are cleared so that child
+// processes don't try to use them as well.
+// https://www.freedesktop.org/software/systemd/man/latest/sd_listen_fds.html
+func SystemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, n)
+	for i := range n {
+		fd := systemdFirstFD + i
+		name := fmt.Sprintf("LISTEN_FD_%d", fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		// FileListener works on a duplicate, the original is not needed
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("systemd listener %s: %w", name, err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rousage/httpfromtcp/internal/request"
+)
+
+// certCheckInterval is how often certificate files are checked for changes,
+// a variable so that tests don't have to wait for it.
+var certCheckInterval = 5 * time.Second
+
+// WithTLSConfig makes the server accept TLS connections only, configured by
+// config. It is cloned, so it can't be changed once the server started, but
+// its GetCertificate or GetConfigForClient callbacks can be used to change
+// certificates. Combined with WithTLSCertificate, GetCertificate is asked
+// first and the certificate files are used when it returns nil.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = config
+	}
+}
+
+// WithTLSCertificate makes the server accept TLS connections only, with the
+// certificate and key in the given PEM files. It can be used several times
+// to serve different certificates, picked by the server name the client
+// asks for (SNI), the first one being the default. The files are reloaded
+// when they change, e.g. when the certificate is renewed. It can be combined
+// with WithTLSConfig to tune the rest of the TLS configuration.
+func WithTLSCertificate(certFile, keyFile string) Option {
+	return func(s *Server) {
+		s.certFiles = append(s.certFiles, certFile, keyFile)
+	}
+}
+
+// ServeTLS is like Serve with WithTLSCertificate(certFile, keyFile), that
+// certificate being the default one.
+func ServeTLS(port int, handler Handler, certFile, keyFile string, opts ...Option) (*Server, error) {
+	opts = append([]Option{WithTLSCertificate(certFile, keyFile)}, opts...)
+	return Serve(port, handler, opts...)
+}
+
+// serverTLSConfig returns the TLS configuration of the server, or nil if it
+// serves plain TCP.
+func (s *Server) serverTLSConfig() (*tls.Config, error) {
+	if s.tlsConfig == nil && len(s.certFiles) == 0 {
+		return nil, nil
+	}
+
+	var config *tls.Config
+	if s.tlsConfig != nil {
+		config = s.tlsConfig.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+	// Tell ALPN clients which protocol they will get
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{"http/1.1"}
+	}
+
+	if len(s.certFiles) > 0 {
+		certs, err := newCertReloader(s.certFiles)
+		if err != nil {
+			return nil, err
+		}
+		fallback := len(config.Certificates) > 0
+		getCertificate := config.GetCertificate
+		config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if getCertificate != nil {
+				cert, err := getCertificate(hello)
+				if cert != nil || err != nil {
+					return cert, err
+				}
+			}
+			return certs.getCertificate(hello, fallback)
+		}
+	}
+
+	return config, nil
+}
+
+// certReloader holds certificates loaded from files, and reloads them when
+// the files change.
+type certReloader struct {
+	// files are pairs of certificate and key files
+	files []string
+
+	mu        sync.Mutex
+	certs     []*tls.Certificate
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(files []string) (*certReloader, error) {
+	r := &certReloader{
+		files:    files,
+		certs:    make([]*tls.Certificate, len(files)/2),
+		modTimes: make([]time.Time, len(files)),
+	}
+	for i := range r.certs {
+		if err := r.load(i); err != nil {
+			return nil, err
+		}
+	}
+	r.lastCheck = time.Now()
+
+	return r, nil
+}
+
+// load loads the i-th certificate from its files.
+func (r *certReloader) load(i int) error {
+	certFile, keyFile := r.files[2*i], r.files[2*i+1]
+	modTimes := make([]time.Time, 2)
+	for j, file := range []string{certFile, keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[j] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	r.certs[i] = &cert
+	copy(r.modTimes[2*i:], modTimes)
+
+	return nil
+}
+
+// reloadChanged reloads the certificates whose files changed, at most once
+// per certCheckInterval. A certificate failing to load is logged and the
+// previous one kept, since a renewal may be caught halfway through.
+func (r *certReloader) reloadChanged() {
+	if time.Since(r.lastCheck) < certCheckInterval {
+		return
+	}
+	r.lastCheck = time.Now()
+
+	for i := range r.certs {
+		changed := false
+		for j := 2 * i; j < 2*i+2; j++ {
+			info, err := os.Stat(r.files[j])
+			if err == nil && !info.ModTime().Equal(r.modTimes[j]) {
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		if err := r.load(i); err != nil {
+			log.Printf("Error reloading certificate %s: %v", r.files[2*i], err)
+		}
+	}
+}
+
+// getCertificate picks the certificate for the server name the client asks
+// for, or the first one if none matches. If fallback is set, it returns nil
+// instead so that crypto/tls picks one of the Config's Certificates.
+func (r *certReloader) getCertificate(hello *tls.ClientHelloInfo, fallback bool) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reloadChanged()
+	idx := slices.IndexFunc(r.certs, func(cert *tls.Certificate) bool {
+		return hello.SupportsCertificate(cert) == nil
+	})
+	if idx != -1 {
+		return r.certs[idx], nil
+	}
+	if fallback {
+		return nil, nil
+	}
+	return r.certs[0], nil
+}
+
+// setTLSState records the TLS state of the connection on the request, if
+// it is a TLS connection.
+func setTLSState(req *request.Request, conn net.Conn) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return
+	}
+	state := tlsConn.ConnectionState()
+	req.TLS = &state
+}